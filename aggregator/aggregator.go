@@ -6,14 +6,56 @@ import (
 	"github.com/kuangyh/saw"
 )
 
-var ErrNotMergeable = errors.New("saws not compatible to be merged")
+var (
+	ErrNotMergeable = errors.New("saws not compatible to be merged")
+	ErrIntOverflow  = errors.New("int64 overflow")
+	ErrSingularFit  = errors.New("singular fit, x has no variance")
+	ErrNotMetric    = errors.New("value not convertible to metric")
+)
 
 // Most aggregators receives (combination) of Metric in Emit()
 type Metric float64
 
+// Datum values failed to convert to Metric in aggregators.
+var metricErrVar = saw.ReportInt("aggregator", "metricErrors")
+
+// ToMetric converts a numeric value to Metric, accepted types are Metric,
+// float64, float32, int, int32 and int64, e.g. float64 from JSON decoded
+// values. Returns ErrNotMetric for other types.
+func ToMetric(v interface{}) (Metric, error) {
+	switch value := v.(type) {
+	case Metric:
+		return value, nil
+	case float64:
+		return Metric(value), nil
+	case float32:
+		return Metric(value), nil
+	case int:
+		return Metric(value), nil
+	case int32:
+		return Metric(value), nil
+	case int64:
+		return Metric(value), nil
+	}
+	return 0, ErrNotMetric
+}
+
+// Converts datum.Value by ToMetric(), failures are counted in
+// aggregator.metricErrors instead of panic.
+func datumMetric(datum saw.Datum) (Metric, error) {
+	metric, err := ToMetric(datum.Value)
+	if err != nil {
+		metricErrVar.Add(1)
+	}
+	return metric, err
+}
+
 // In addition to normal Saw interface, Aggregators should be able to "merge",
 // that means it can further aggregate multiple Aggregator saw (may be on different
-// instances) into one to provide aggregated result.
+// instances) into one to provide aggregated result. MergeFrom() takes other
+// aggregator of the same type or its Export(), returns ErrNotMergeable for
+// others, so that aggregators work as table items, see table.MergeInto().
 type Merger interface {
-	MergeFrom(other saw.Saw) error
+	saw.ExportSaw
+	saw.MergeSaw
 }
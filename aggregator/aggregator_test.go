@@ -0,0 +1,155 @@
+package aggregator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+type mergerSaw interface {
+	saw.Saw
+	Merger
+}
+
+var mergerTests = []struct {
+	name   string
+	new    func() mergerSaw
+	values []interface{}
+}{
+	{"Sum", func() mergerSaw { return &Sum{} }, []interface{}{1.5, 2, 3.25, -1}},
+	{"Count", func() mergerSaw { return &Count{} }, []interface{}{nil, nil, nil}},
+	{"SumInt", func() mergerSaw { return &SumInt{} }, []interface{}{int64(1) << 60, int64(3), int64(-7)}},
+	{"GroupCount", func() mergerSaw { return &GroupCount{} }, []interface{}{"a", "b", "a", "c"}},
+	{"Collect", func() mergerSaw { return NewBoundedCollect(5) }, []interface{}{"a", "b", "c", "d"}},
+	{"ArgMax", func() mergerSaw { return &ArgMax{} }, []interface{}{3, 1, 4, 1, 5}},
+	{"ArgMin", func() mergerSaw { return &ArgMin{} }, []interface{}{3, 1, 4, 1, 5}},
+	{"LinearRegression", func() mergerSaw { return &LinearRegression{} }, []interface{}{
+		XYSample{1, 2}, XYSample{2, 4.5}, XYSample{3, 5}, XYSample{4, 8.5}}},
+	{"Moments", func() mergerSaw { return &Moments{} }, []interface{}{1, 2, 2, 3, 10, 4}},
+	{"GlobalTopK", func() mergerSaw { return NewGlobalTopK(3) }, []interface{}{5, 3, 8, 1, 9, 2}},
+	{"Quantile", func() mergerSaw { return NewQuantile(2, 2) }, []interface{}{
+		5, 3, 8, 1, 9, 2, 7, 7, 4, 6, 0, 11, 13, 12, 10}},
+}
+
+func emitAll(t *testing.T, s saw.Saw, values []interface{}) {
+	for i, value := range values {
+		key := saw.DatumKey(string(rune('a' + i)))
+		if err := s.Emit(saw.Datum{Key: key, Value: value}); err != nil {
+			t.Fatalf("Emit(%v) returns %v", value, err)
+		}
+	}
+}
+
+// Merging Export() of a saw, through JSON as tables persist it, is the same
+// as merging the saw itself.
+func TestMergeFromExport(t *testing.T) {
+	for _, test := range mergerTests {
+		half := len(test.values) / 2
+		other := test.new()
+		emitAll(t, other, test.values[half:])
+
+		direct := test.new()
+		emitAll(t, direct, test.values[:half])
+		if err := direct.MergeFrom(other); err != nil {
+			t.Fatalf("%s: MergeFrom(saw) returns %v", test.name, err)
+		}
+
+		exported, err := other.Export()
+		if err != nil {
+			t.Fatalf("%s: Export() returns %v", test.name, err)
+		}
+		buf, err := saw.JSONEncoder{}.EncodeValue(exported, nil)
+		if err != nil {
+			t.Fatalf("%s: encode Export() returns %v", test.name, err)
+		}
+		decoded, err := saw.NewJSONDecoder(exported).DecodeValue(buf)
+		if err != nil {
+			t.Fatalf("%s: decode Export() returns %v", test.name, err)
+		}
+		restored := test.new()
+		emitAll(t, restored, test.values[:half])
+		if err := restored.MergeFrom(decoded); err != nil {
+			t.Fatalf("%s: MergeFrom(Export()) returns %v", test.name, err)
+		}
+
+		want, wantErr := direct.Result(context.Background())
+		got, gotErr := restored.Result(context.Background())
+		if !reflect.DeepEqual(got, want) || gotErr != wantErr {
+			t.Errorf("%s: Result() after merging Export() = %v, %v, want %v, %v",
+				test.name, got, gotErr, want, wantErr)
+		}
+		if err := restored.MergeFrom(struct{}{}); err != ErrNotMergeable {
+			t.Errorf("%s: MergeFrom(struct{}{}) returns %v, want ErrNotMergeable", test.name, err)
+		}
+	}
+}
+
+// Export() is a snapshot, later Emit() doesn't change it.
+func TestExportIsSnapshot(t *testing.T) {
+	for _, test := range mergerTests {
+		s := test.new()
+		emitAll(t, s, test.values)
+		exported, err := s.Export()
+		if err != nil {
+			t.Fatalf("%s: Export() returns %v", test.name, err)
+		}
+		before, _ := saw.JSONEncoder{}.EncodeValue(exported, nil)
+		before = append([]byte(nil), before...)
+		emitAll(t, s, test.values)
+		after, _ := saw.JSONEncoder{}.EncodeValue(exported, nil)
+		if string(before) != string(after) {
+			t.Errorf("%s: Export() changed by Emit() from %s to %s", test.name, before, after)
+		}
+	}
+}
+
+// Windows of aggregator frames built on different instances merge.
+func TestWindowMergeFromAggregators(t *testing.T) {
+	newWindow := func() *saw.Window {
+		return saw.NewWindow(saw.WindowSpec{
+			Name:         "aggregatorWindow",
+			FrameFactory: func(name string, seq saw.SeqID) (saw.Saw, error) { return &Sum{}, nil },
+			SeqFunc:      func(datum saw.Datum) saw.SeqID { return saw.SeqID(datum.Value.(int)) },
+			WindowSize:   3,
+			KeepResults:  true,
+		})
+	}
+	win, other := newWindow(), newWindow()
+	for _, value := range []int{1, 2, 2} {
+		win.Emit(saw.Datum{Value: value})
+	}
+	for _, value := range []int{2, 3} {
+		other.Emit(saw.Datum{Value: value})
+	}
+	if err := win.MergeFrom(other); err != nil {
+		t.Fatal(err)
+	}
+	results, err := win.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[saw.SeqID]interface{}{1: Metric(1), 2: Metric(6), 3: Metric(3)}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("merged window results %v, want %v", results, want)
+	}
+}
+
+// LinearRegression takes XYSample by value or pointer, fails other values.
+func TestLinearRegressionEmitValues(t *testing.T) {
+	lr := &LinearRegression{}
+	for _, value := range []interface{}{XYSample{X: 1, Y: 1}, &XYSample{X: 2, Y: 2}} {
+		if err := lr.Emit(saw.Datum{Value: value}); err != nil {
+			t.Errorf("Emit(%v) returns %v", value, err)
+		}
+	}
+	for _, value := range []interface{}{1.0, "x", (*XYSample)(nil)} {
+		if err := lr.Emit(saw.Datum{Value: value}); err != ErrNotMetric {
+			t.Errorf("Emit(%v) returns %v, want %v", value, err, ErrNotMetric)
+		}
+	}
+	if lr.Count != 2 {
+		t.Errorf("Count = %v, want 2", lr.Count)
+	}
+}
@@ -0,0 +1,105 @@
+package aggregator
+
+import (
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+// argExtreme tracks the label of max score, or min score for ArgMin. Ties keep
+// the smaller label, so that result doesn't depend on Emit() or merge order.
+type argExtreme struct {
+	Best  TopKEntry
+	Found bool
+}
+
+func (ae *argExtreme) update(entry TopKEntry, min bool) {
+	if !ae.Found {
+		ae.Best = entry
+		ae.Found = true
+		return
+	}
+	better := entry.Score > ae.Best.Score
+	if min {
+		better = entry.Score < ae.Best.Score
+	}
+	if better || (entry.Score == ae.Best.Score && entry.Label < ae.Best.Label) {
+		ae.Best = entry
+	}
+}
+
+// datum.Value is either TopKEntry, or score with datum.Key as label.
+func (ae *argExtreme) emit(datum saw.Datum, min bool) error {
+	if entry, ok := datum.Value.(TopKEntry); ok {
+		ae.update(entry, min)
+		return nil
+	}
+	score, err := datumMetric(datum)
+	if err != nil {
+		return err
+	}
+	ae.update(TopKEntry{Label: datum.Key, Score: score}, min)
+	return nil
+}
+
+func (ae *argExtreme) mergeFrom(other *argExtreme, min bool) {
+	if other.Found {
+		ae.update(other.Best, min)
+	}
+}
+
+// ArgMax aggregator saw keeps the highest score and the label that achieved
+// it, e.g. which business has the highest mean rating. Emit() takes TopKEntry,
+// or numeric score (see ToMetric()) with datum.Key as label.
+type ArgMax struct {
+	argExtreme
+}
+
+func (am *ArgMax) Emit(datum saw.Datum) error {
+	return am.emit(datum, false)
+}
+
+func (am *ArgMax) Export() (interface{}, error) {
+	return &ArgMax{am.argExtreme}, nil
+}
+
+func (am *ArgMax) MergeFrom(other interface{}) error {
+	otherArgMax, ok := other.(*ArgMax)
+	if !ok {
+		return ErrNotMergeable
+	}
+	am.mergeFrom(&otherArgMax.argExtreme, false)
+	return nil
+}
+
+// Returns TopKEntry of the highest score, zero value when nothing was emitted.
+func (am *ArgMax) Result(ctx context.Context) (interface{}, error) {
+	return am.Best, nil
+}
+
+// ArgMin aggregator saw keeps the lowest score and the label that achieved it,
+// takes the same input as ArgMax.
+type ArgMin struct {
+	argExtreme
+}
+
+func (am *ArgMin) Emit(datum saw.Datum) error {
+	return am.emit(datum, true)
+}
+
+func (am *ArgMin) Export() (interface{}, error) {
+	return &ArgMin{am.argExtreme}, nil
+}
+
+func (am *ArgMin) MergeFrom(other interface{}) error {
+	otherArgMin, ok := other.(*ArgMin)
+	if !ok {
+		return ErrNotMergeable
+	}
+	am.mergeFrom(&otherArgMin.argExtreme, true)
+	return nil
+}
+
+// Returns TopKEntry of the lowest score, zero value when nothing was emitted.
+func (am *ArgMin) Result(ctx context.Context) (interface{}, error) {
+	return am.Best, nil
+}
@@ -13,12 +13,24 @@ type Sum struct {
 }
 
 func (sum *Sum) Emit(datum saw.Datum) error {
-	sum.Current += datum.Value.(Metric)
+	metric, err := datumMetric(datum)
+	if err != nil {
+		return err
+	}
+	sum.Current += metric
 	return nil
 }
 
-func (sum *Sum) MergeFrom(other saw.Saw) error {
-	sum.Current += other.(*Sum).Current
+func (sum *Sum) Export() (interface{}, error) {
+	return &Sum{Current: sum.Current}, nil
+}
+
+func (sum *Sum) MergeFrom(other interface{}) error {
+	otherSum, ok := other.(*Sum)
+	if !ok {
+		return ErrNotMergeable
+	}
+	sum.Current += otherSum.Current
 	return nil
 }
 
@@ -26,6 +38,80 @@ func (sum *Sum) Result(ctx context.Context) (interface{}, error) {
 	return sum.Current, nil
 }
 
+// Count aggregator saw counts datums, datum.Value is ignored.
+type Count struct {
+	Current int64
+}
+
+func (c *Count) Emit(datum saw.Datum) error {
+	c.Current++
+	return nil
+}
+
+func (c *Count) Export() (interface{}, error) {
+	return &Count{Current: c.Current}, nil
+}
+
+func (c *Count) MergeFrom(other interface{}) error {
+	otherCount, ok := other.(*Count)
+	if !ok {
+		return ErrNotMergeable
+	}
+	c.Current += otherCount.Current
+	return nil
+}
+
+// Returns int64 count.
+func (c *Count) Result(ctx context.Context) (interface{}, error) {
+	return c.Current, nil
+}
+
+// SumInt aggregator saw sums int64 values exactly, unlike Sum, which loses
+// precision past 2^53. Values overflowing int64 are not added, Emit() returns
+// ErrIntOverflow and Overflowed is set, then Result() returns the partial sum
+// with ErrIntOverflow.
+type SumInt struct {
+	Current    int64
+	Overflowed bool
+}
+
+func (sum *SumInt) add(value int64) error {
+	next := sum.Current + value
+	if (value > 0 && next < sum.Current) || (value < 0 && next > sum.Current) {
+		sum.Overflowed = true
+		return ErrIntOverflow
+	}
+	sum.Current = next
+	return nil
+}
+
+func (sum *SumInt) Emit(datum saw.Datum) error {
+	return sum.add(datum.Value.(int64))
+}
+
+func (sum *SumInt) Export() (interface{}, error) {
+	exported := *sum
+	return &exported, nil
+}
+
+func (sum *SumInt) MergeFrom(other interface{}) error {
+	otherSum, ok := other.(*SumInt)
+	if !ok {
+		return ErrNotMergeable
+	}
+	if otherSum.Overflowed {
+		sum.Overflowed = true
+	}
+	return sum.add(otherSum.Current)
+}
+
+func (sum *SumInt) Result(ctx context.Context) (interface{}, error) {
+	if sum.Overflowed {
+		return sum.Current, ErrIntOverflow
+	}
+	return sum.Current, nil
+}
+
 type MeanState struct {
 	count      Metric
 	sum        Metric
@@ -64,10 +150,116 @@ type Mean struct {
 }
 
 func (m *Mean) Emit(datum saw.Datum) error {
-	m.state.Add(datum.Value.(Metric))
+	metric, err := datumMetric(datum)
+	if err != nil {
+		return err
+	}
+	m.state.Add(metric)
 	return nil
 }
 
 func (m *Mean) Result() (interface{}, error) {
 	return m.state, nil
 }
+
+// GroupCount aggregator saw counts datums by category, datum.Value is expected
+// to be the category string.
+type GroupCount struct {
+	Counts map[string]int64
+}
+
+func (gc *GroupCount) Emit(datum saw.Datum) error {
+	if gc.Counts == nil {
+		gc.Counts = make(map[string]int64)
+	}
+	gc.Counts[datum.Value.(string)]++
+	return nil
+}
+
+// Export returns a *GroupCount with a copy of counts.
+func (gc *GroupCount) Export() (interface{}, error) {
+	counts := make(map[string]int64, len(gc.Counts))
+	for category, count := range gc.Counts {
+		counts[category] = count
+	}
+	return &GroupCount{Counts: counts}, nil
+}
+
+func (gc *GroupCount) MergeFrom(other interface{}) error {
+	otherGroupCount, ok := other.(*GroupCount)
+	if !ok {
+		return ErrNotMergeable
+	}
+	otherCounts := otherGroupCount.Counts
+	if gc.Counts == nil && len(otherCounts) > 0 {
+		gc.Counts = make(map[string]int64)
+	}
+	for category, count := range otherCounts {
+		gc.Counts[category] += count
+	}
+	return nil
+}
+
+// Returns map[string]int64 of counts by category.
+func (gc *GroupCount) Result(ctx context.Context) (interface{}, error) {
+	if gc.Counts == nil {
+		return map[string]int64{}, nil
+	}
+	return gc.Counts, nil
+}
+
+// Collect aggregator saw keeps all datum.Value in memory, for small groups,
+// e.g. events of a session. Zero value is unbounded, see NewBoundedCollect().
+type Collect struct {
+	Values []interface{}
+	// # of values dropped for exceeding max
+	Overflow int64
+	max      int
+}
+
+// NewBoundedCollect creates a Collect keeping at most max values, later ones
+// are dropped and counted in Overflow.
+func NewBoundedCollect(max int) *Collect {
+	return &Collect{max: max}
+}
+
+func (c *Collect) add(values ...interface{}) {
+	if c.max > 0 && len(c.Values)+len(values) > c.max {
+		room := c.max - len(c.Values)
+		if room < 0 {
+			room = 0
+		}
+		c.Overflow += int64(len(values) - room)
+		values = values[:room]
+	}
+	c.Values = append(c.Values, values...)
+}
+
+func (c *Collect) Emit(datum saw.Datum) error {
+	c.add(datum.Value)
+	return nil
+}
+
+// Export returns a *Collect with a copy of values, max is not exported.
+func (c *Collect) Export() (interface{}, error) {
+	return &Collect{
+		Values:   append([]interface{}(nil), c.Values...),
+		Overflow: c.Overflow,
+	}, nil
+}
+
+// MergeFrom appends values of other, bounded by max of c.
+func (c *Collect) MergeFrom(other interface{}) error {
+	otherCollect, ok := other.(*Collect)
+	if !ok {
+		return ErrNotMergeable
+	}
+	c.Overflow += otherCollect.Overflow
+	c.add(otherCollect.Values...)
+	return nil
+}
+
+// Returns []interface{} of values in order of Emit().
+func (c *Collect) Result(ctx context.Context) (interface{}, error) {
+	return c.Values, nil
+}
@@ -0,0 +1,140 @@
+package aggregator
+
+import (
+	"math"
+
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+// MomentsState tracks mean and 2nd to 4th central moments online, for
+// distribution shape, beyond what MeanState's stddev captures.
+type MomentsState struct {
+	count Metric
+	mean  Metric
+	// Sums of powers of differences from mean
+	m2 Metric
+	m3 Metric
+	m4 Metric
+}
+
+func (ms *MomentsState) Add(metric Metric) {
+	n1 := ms.count
+	ms.count += 1.0
+	n := ms.count
+	delta := metric - ms.mean
+	deltaN := delta / n
+	deltaN2 := deltaN * deltaN
+	term1 := delta * deltaN * n1
+	ms.mean += deltaN
+	ms.m4 += term1*deltaN2*(n*n-3*n+3) + 6*deltaN2*ms.m2 - 4*deltaN*ms.m3
+	ms.m3 += term1*deltaN*(n-2) - 3*deltaN*ms.m2
+	ms.m2 += term1
+}
+
+// MergeFrom combines moments of other into ms, as if all metrics added to
+// other were added to ms.
+func (ms *MomentsState) MergeFrom(other *MomentsState) {
+	if other.count == 0 {
+		return
+	}
+	if ms.count == 0 {
+		*ms = *other
+		return
+	}
+	na, nb := ms.count, other.count
+	n := na + nb
+	delta := other.mean - ms.mean
+	delta2 := delta * delta
+	delta3 := delta2 * delta
+	delta4 := delta2 * delta2
+
+	m4 := ms.m4 + other.m4 +
+		delta4*na*nb*(na*na-na*nb+nb*nb)/(n*n*n) +
+		6*delta2*(na*na*other.m2+nb*nb*ms.m2)/(n*n) +
+		4*delta*(na*other.m3-nb*ms.m3)/n
+	m3 := ms.m3 + other.m3 +
+		delta3*na*nb*(na-nb)/(n*n) +
+		3*delta*(na*other.m2-nb*ms.m2)/n
+	m2 := ms.m2 + other.m2 + delta2*na*nb/n
+
+	ms.mean += delta * nb / n
+	ms.count = n
+	ms.m2, ms.m3, ms.m4 = m2, m3, m4
+}
+
+func (ms *MomentsState) Count() Metric {
+	return ms.count
+}
+
+func (ms *MomentsState) Mean() Metric {
+	return ms.mean
+}
+
+// Sample stddev, same as MeanState.Stddev().
+func (ms *MomentsState) Stddev() Metric {
+	if ms.count <= 1 {
+		return 0.0
+	}
+	return Metric(math.Sqrt(float64(ms.m2 / (ms.count - 1.0))))
+}
+
+// Population skewness, 0 for symmetric distributions.
+func (ms *MomentsState) Skewness() Metric {
+	if ms.m2 == 0 {
+		return 0.0
+	}
+	return Metric(math.Sqrt(float64(ms.count))) * ms.m3 /
+		Metric(math.Pow(float64(ms.m2), 1.5))
+}
+
+// Population excess kurtosis, 0 for normal distribution, positive for heavy
+// tails.
+func (ms *MomentsState) Kurtosis() Metric {
+	if ms.m2 == 0 {
+		return 0.0
+	}
+	return ms.count*ms.m4/(ms.m2*ms.m2) - 3.0
+}
+
+// Moments aggregator saw tracks MomentsState of metrics.
+type Moments struct {
+	state MomentsState
+}
+
+func (m *Moments) Emit(datum saw.Datum) error {
+	metric, err := datumMetric(datum)
+	if err != nil {
+		return err
+	}
+	m.state.Add(metric)
+	return nil
+}
+
+// MomentsExport is Export() of Moments, fields of its MomentsState.
+type MomentsExport struct {
+	N, Mean, M2, M3, M4 Metric
+}
+
+func (m *Moments) Export() (interface{}, error) {
+	ms := &m.state
+	return &MomentsExport{N: ms.count, Mean: ms.mean, M2: ms.m2, M3: ms.m3, M4: ms.m4}, nil
+}
+
+// MergeFrom takes other Moments or its Export().
+func (m *Moments) MergeFrom(other interface{}) error {
+	switch o := other.(type) {
+	case *Moments:
+		m.state.MergeFrom(&o.state)
+	case *MomentsExport:
+		m.state.MergeFrom(&MomentsState{count: o.N, mean: o.Mean, m2: o.M2, m3: o.M3, m4: o.M4})
+	default:
+		return ErrNotMergeable
+	}
+	return nil
+}
+
+// Returns MomentsState
+func (m *Moments) Result(ctx context.Context) (interface{}, error) {
+	return m.state, nil
+}
@@ -1,6 +1,9 @@
 package aggregator
 
 import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
 	"sort"
 
 	"github.com/kuangyh/saw"
@@ -21,6 +24,11 @@ type QuantileState struct {
 	max          Metric
 	hasMetric    bool
 	collapseFlip int
+
+	// Deterministic mode, see NewDeterministicQuantileState().
+	deterministic bool
+	// States merged in deterministic mode, immutable once added.
+	parts []*QuantileState
 }
 
 func NewQuantileState(bufferSize int) *QuantileState {
@@ -30,6 +38,26 @@ func NewQuantileState(bufferSize int) *QuantileState {
 	}
 }
 
+// NewDeterministicQuantileState creates a QuantileState whose Result() is
+// reproducible bit-for-bit regardless of the order of MergeFrom() calls, e.g.
+// merging shards of a table, given every shard saw the same metrics in the
+// same order.
+//
+// MergeFrom() keeps a copy of other instead of merging it right away; Result()
+// merges the state's own metrics and all copies into a fresh state in an order
+// decided by their content. Collapses always alternate from the same flip,
+// so which samples survive is a function of the input only.
+//
+// The tradeoff: memory grows with # of merges until Result(), each Result()
+// pays for the full merge, and sample selection is no longer randomized per
+// instance, so inputs with periodic patterns matching the alternation can
+// bias estimates beyond the usual rank error bound.
+func NewDeterministicQuantileState(bufferSize int) *QuantileState {
+	qs := NewQuantileState(bufferSize)
+	qs.deterministic = true
+	return qs
+}
+
 type metricSort []Metric
 
 func (ms metricSort) Len() int           { return len(ms) }
@@ -80,9 +108,10 @@ func (qs *QuantileState) collapse(left, right []Metric) []Metric {
 			pick = right[rightIdx]
 			rightIdx++
 		}
-		// 0.5 sample
+		// 0.5 sample, picks either odd or even positions (1-based) of merged
+		// sequence depends on collapseFlip.
 		if (leftIdx+rightIdx)%2 == qs.collapseFlip {
-			merged[(leftIdx+rightIdx)/2] = pick
+			merged[(leftIdx+rightIdx-1)/2] = pick
 		}
 	}
 	qs.collapseFlip = 1 - qs.collapseFlip
@@ -101,19 +130,13 @@ func (qs *QuantileState) mergeSampleStack(startLevel int, buf []Metric) {
 	}
 	// Come to top, add new level(s), note that when doing mergeFrom, startLevel
 	// can already > len(qs.sampleStack), the loop is neccesary
-	for len(qs.sampleStack) < level {
+	for len(qs.sampleStack) <= level {
 		qs.sampleStack = append(qs.sampleStack, nil)
 	}
 	qs.sampleStack[level] = buf
 }
 
-func (qs *QuantileState) MergeFrom(other *QuantileState) error {
-	if qs.bufferSize != other.bufferSize {
-		return ErrNotMergeable
-	}
-	if !other.hasMetric {
-		return nil
-	}
+func (qs *QuantileState) mergeMinMax(other *QuantileState) {
 	if qs.hasMetric {
 		if other.min < qs.min {
 			qs.min = other.min
@@ -124,9 +147,30 @@ func (qs *QuantileState) MergeFrom(other *QuantileState) error {
 	} else {
 		qs.min = other.min
 		qs.max = other.max
+		qs.hasMetric = true
 	}
+}
+
+// MergeFrom merges other into qs exactly as if metrics added to other were
+// added to qs, ErrNotMergeable returns when bufferSize differs, see
+// MergeFromResampling().
+func (qs *QuantileState) MergeFrom(other *QuantileState) error {
+	if qs.bufferSize != other.bufferSize {
+		return ErrNotMergeable
+	}
+	if qs.deterministic {
+		qs.addParts(other)
+		return nil
+	}
+	other = other.settled()
+	if !other.hasMetric {
+		return nil
+	}
+	qs.mergeMinMax(other)
 	for i := len(other.sampleStack) - 1; i >= 0; i-- {
-		qs.mergeSampleStack(i, other.sampleStack[i])
+		if other.sampleStack[i] != nil {
+			qs.mergeSampleStack(i, other.sampleStack[i])
+		}
 	}
 	for _, metric := range other.leaf {
 		qs.AddMetric(metric)
@@ -134,6 +178,127 @@ func (qs *QuantileState) MergeFrom(other *QuantileState) error {
 	return nil
 }
 
+// MergeFromResampling merges other into qs even when their bufferSize differ,
+// e.g. sketches produced by different configs. A sample at level L weighs
+// 2^(L+1) regardless of bufferSize, so samples of other are regrouped into
+// buffers of qs.bufferSize at the same level; remainders not filling a buffer
+// are split into 2 samples of the level below, eventually added as metrics.
+//
+// Total weight is kept but it's less accurate than MergeFrom(): regrouping
+// re-sorts samples chosen by other's collapses and splitting repeats samples
+// rather than adding information. Same as MergeFrom() when bufferSize equals.
+func (qs *QuantileState) MergeFromResampling(other *QuantileState) error {
+	if qs.bufferSize == other.bufferSize {
+		return qs.MergeFrom(other)
+	}
+	if qs.deterministic {
+		resampled := NewQuantileState(qs.bufferSize)
+		resampled.MergeFromResampling(other)
+		qs.addParts(resampled)
+		return nil
+	}
+	other = other.settled()
+	if !other.hasMetric {
+		return nil
+	}
+	qs.mergeMinMax(other)
+	var pending []Metric
+	for level := len(other.sampleStack) - 1; level >= 0; level-- {
+		pending = append(pending, other.sampleStack[level]...)
+		for len(pending) >= qs.bufferSize {
+			buf := make([]Metric, qs.bufferSize)
+			copy(buf, pending)
+			pending = pending[qs.bufferSize:]
+			sort.Sort(metricSort(buf))
+			qs.mergeSampleStack(level, buf)
+		}
+		// Split remainders into the level below.
+		split := make([]Metric, 0, len(pending)*2)
+		for _, metric := range pending {
+			split = append(split, metric, metric)
+		}
+		pending = split
+	}
+	for _, metric := range append(pending, other.leaf...) {
+		qs.AddMetric(metric)
+	}
+	return nil
+}
+
+// Adds copy of other, and parts merged into it, as parts of qs.
+func (qs *QuantileState) addParts(other *QuantileState) {
+	if other.hasMetric {
+		part := &QuantileState{
+			bufferSize:  other.bufferSize,
+			leaf:        append([]Metric(nil), other.leaf...),
+			sampleStack: make([][]Metric, len(other.sampleStack)),
+			min:         other.min,
+			max:         other.max,
+			hasMetric:   true,
+		}
+		// Sample buffers are never modified in place.
+		copy(part.sampleStack, other.sampleStack)
+		qs.parts = append(qs.parts, part)
+	}
+	qs.parts = append(qs.parts, other.parts...)
+}
+
+// Returns qs itself when it has no parts, otherwise a new state merging qs and
+// its parts in order of their content.
+func (qs *QuantileState) settled() *QuantileState {
+	if len(qs.parts) == 0 {
+		return qs
+	}
+	var own QuantileState
+	own.addParts(qs)
+	parts := make(partSort, len(own.parts))
+	for i, part := range own.parts {
+		parts[i] = fingerprintedPart{part.fingerprint(), part}
+	}
+	sort.Sort(parts)
+	merged := NewQuantileState(qs.bufferSize)
+	for _, part := range parts {
+		merged.MergeFrom(part.state)
+	}
+	return merged
+}
+
+type fingerprintedPart struct {
+	fingerprint uint64
+	state       *QuantileState
+}
+
+type partSort []fingerprintedPart
+
+func (ps partSort) Len() int           { return len(ps) }
+func (ps partSort) Less(i, j int) bool { return ps[i].fingerprint < ps[j].fingerprint }
+func (ps partSort) Swap(i, j int)      { t := ps[i]; ps[i] = ps[j]; ps[j] = t }
+
+// Hash of metrics and samples, parts of the same fingerprint are identical for
+// merging purposes.
+func (qs *QuantileState) fingerprint() uint64 {
+	hash := fnv.New64a()
+	var buf [8]byte
+	writeMetric := func(metric Metric) {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(float64(metric)))
+		hash.Write(buf[:])
+	}
+	// Leaf order depends on input order, which doesn't change the result.
+	leaf := append([]Metric(nil), qs.leaf...)
+	sort.Sort(metricSort(leaf))
+	for _, metric := range leaf {
+		writeMetric(metric)
+	}
+	for level, sampleBuf := range qs.sampleStack {
+		binary.LittleEndian.PutUint64(buf[:], uint64(level))
+		hash.Write(buf[:])
+		for _, metric := range sampleBuf {
+			writeMetric(metric)
+		}
+	}
+	return hash.Sum64()
+}
+
 type weightedMetric struct {
 	metric Metric
 	weight int
@@ -146,6 +311,7 @@ func (ws weightedMetricSort) Less(i, j int) bool { return ws[i].metric < ws[j].m
 func (ws weightedMetricSort) Swap(i, j int)      { t := ws[i]; ws[i] = ws[j]; ws[j] = t }
 
 func (qs *QuantileState) Result() Quantile {
+	qs = qs.settled()
 	var queryBuf []weightedMetric
 	total := 0
 	for _, leafMetric := range qs.leaf {
@@ -153,7 +319,7 @@ func (qs *QuantileState) Result() Quantile {
 		total++
 	}
 	for level, sampleBuf := range qs.sampleStack {
-		weight := 1 << uint(level+2)
+		weight := 1 << uint(level+1)
 		for _, metric := range sampleBuf {
 			queryBuf = append(queryBuf, weightedMetric{metric: metric, weight: weight})
 			total += weight
@@ -229,13 +395,85 @@ func (q *Quantile) Get(numBuckets int) []Metric {
 	return output
 }
 
+// QuantileBucket is a band between two quantile boundaries, with approximate
+// # metrics in (Lo, Hi], the first bucket includes Lo as well.
+type QuantileBucket struct {
+	Lo, Hi Metric
+	Count  int
+}
+
+// Buckets returns numBuckets bands between boundaries returned by
+// Get(numBuckets), with approximate count of each band from sample weights.
+//
+// When numBuckets <= 1, a single bucket of [min, max] with total count returns.
+// Buckets with equal boundaries, e.g. when all metrics are equal, get count 0
+// except the first of them. nil returns when no metric was added.
+func (q *Quantile) Buckets(numBuckets int) []QuantileBucket {
+	if q.total == 0 {
+		return nil
+	}
+	bounds := q.Get(numBuckets)
+	buckets := make([]QuantileBucket, len(bounds)-1)
+	for i := range buckets {
+		buckets[i].Lo = bounds[i]
+		buckets[i].Hi = bounds[i+1]
+	}
+	idx := 0
+	for _, wm := range q.queryBuf {
+		for idx < len(buckets)-1 && wm.metric > buckets[idx].Hi {
+			idx++
+		}
+		buckets[idx].Count += wm.weight
+	}
+	return buckets
+}
+
+// MAD returns median absolute deviation, the median of |x - median|.
+//
+// Raw metrics are not retained, so it's approximated from weighted samples:
+// median is taken by At(0.5), then median of the samples' absolute deviations
+// from it, weighted the same. Error is in the same rank error bound as At(),
+// compounded by error of the median itself.
+func (q *Quantile) MAD() Metric {
+	if len(q.queryBuf) == 0 {
+		return 0.0
+	}
+	median := q.At(0.5)
+	deviations := make([]weightedMetric, len(q.queryBuf))
+	for i, wm := range q.queryBuf {
+		deviation := wm.metric - median
+		if deviation < 0 {
+			deviation = -deviation
+		}
+		deviations[i] = weightedMetric{metric: deviation, weight: wm.weight}
+	}
+	sort.Sort(weightedMetricSort(deviations))
+	targetWeight := float64(q.total) * 0.5
+	var currWeight float64
+	for _, wm := range deviations {
+		currWeight += float64(wm.weight)
+		if currWeight >= targetWeight {
+			return wm.metric
+		}
+	}
+	return deviations[len(deviations)-1].metric
+}
+
 // QuantileSaw wraps QuantileState to provide Saw interface.
 type QuantileSaw struct {
+	// When true, MergeFrom() accepts QuantileSaw of different bufferSize, see
+	// QuantileState.MergeFromResampling(). Strict by default.
+	MergeResampling bool
+
 	state *QuantileState
 }
 
 func (s *QuantileSaw) Emit(datum saw.Datum) error {
-	s.state.AddMetric(datum.Value.(Metric))
+	metric, err := datumMetric(datum)
+	if err != nil {
+		return err
+	}
+	s.state.AddMetric(metric)
 	return nil
 }
 
@@ -244,8 +482,53 @@ func (s *QuantileSaw) Result(ctx context.Context) (interface{}, error) {
 	return s.state.Result(), nil
 }
 
-func (s *QuantileSaw) MergeFrom(other saw.Saw) error {
-	return s.state.MergeFrom(other.(*QuantileSaw).state)
+// QuantileExport is Export() of QuantileSaw, fields of its QuantileState with
+// merged parts settled.
+type QuantileExport struct {
+	BufferSize  int
+	Leaf        []Metric
+	SampleStack [][]Metric
+	Min, Max    Metric
+	HasMetric   bool
+}
+
+func (s *QuantileSaw) Export() (interface{}, error) {
+	state := s.state.settled()
+	sampleStack := make([][]Metric, len(state.sampleStack))
+	// Sample buffers are never modified in place.
+	copy(sampleStack, state.sampleStack)
+	return &QuantileExport{
+		BufferSize:  state.bufferSize,
+		Leaf:        append([]Metric(nil), state.leaf...),
+		SampleStack: sampleStack,
+		Min:         state.min,
+		Max:         state.max,
+		HasMetric:   state.hasMetric,
+	}, nil
+}
+
+// MergeFrom takes other QuantileSaw or its Export().
+func (s *QuantileSaw) MergeFrom(other interface{}) error {
+	var otherState *QuantileState
+	switch o := other.(type) {
+	case *QuantileSaw:
+		otherState = o.state
+	case *QuantileExport:
+		otherState = &QuantileState{
+			bufferSize:  o.BufferSize,
+			leaf:        o.Leaf,
+			sampleStack: o.SampleStack,
+			min:         o.Min,
+			max:         o.Max,
+			hasMetric:   o.HasMetric,
+		}
+	default:
+		return ErrNotMergeable
+	}
+	if s.MergeResampling {
+		return s.state.MergeFromResampling(otherState)
+	}
+	return s.state.MergeFrom(otherState)
 }
 
 // Creates a new QuantileSaw, desiresNumBuckets and samplesPerBucket determines
@@ -261,3 +544,10 @@ func NewQuantile(desireNumBuckets int, samplesPerBucket int) *QuantileSaw {
 	bufferSize := int(desireNumBuckets * samplesPerBucket)
 	return &QuantileSaw{state: NewQuantileState(bufferSize)}
 }
+
+// NewDeterministicQuantile is NewQuantile() with reproducible results across
+// merge orders, see NewDeterministicQuantileState().
+func NewDeterministicQuantile(desireNumBuckets int, samplesPerBucket int) *QuantileSaw {
+	bufferSize := int(desireNumBuckets * samplesPerBucket)
+	return &QuantileSaw{state: NewDeterministicQuantileState(bufferSize)}
+}
@@ -0,0 +1,111 @@
+package aggregator
+
+import (
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+// XYSample is value emitted to LinearRegression.
+type XYSample struct {
+	X, Y Metric
+}
+
+// LinearFit is ordinary least squares fit Y = Slope * X + Intercept, R2 is the
+// coefficient of determination, 1 when Y has no variance.
+type LinearFit struct {
+	Slope     Metric
+	Intercept Metric
+	R2        Metric
+}
+
+// LinearRegression aggregator saw fits a line to XYSample by ordinary least
+// squares, e.g. rating trend over time per key. Instead of raw sums, it tracks
+// means and co-moments online, same as MomentsState, so large X (timestamps)
+// don't lose precision.
+type LinearRegression struct {
+	Count Metric
+	MeanX Metric
+	MeanY Metric
+	// Sums of products of differences from means
+	M2X Metric
+	M2Y Metric
+	CXY Metric
+}
+
+func (lr *LinearRegression) add(sample XYSample) {
+	lr.Count += 1.0
+	deltaX := sample.X - lr.MeanX
+	lr.MeanX += deltaX / lr.Count
+	deltaY := sample.Y - lr.MeanY
+	lr.MeanY += deltaY / lr.Count
+	lr.M2X += deltaX * (sample.X - lr.MeanX)
+	lr.M2Y += deltaY * (sample.Y - lr.MeanY)
+	lr.CXY += deltaX * (sample.Y - lr.MeanY)
+}
+
+// Takes XYSample or *XYSample, other values are counted in
+// aggregator.metricErrors and ErrNotMetric returns.
+func (lr *LinearRegression) Emit(datum saw.Datum) error {
+	switch sample := datum.Value.(type) {
+	case XYSample:
+		lr.add(sample)
+	case *XYSample:
+		if sample == nil {
+			metricErrVar.Add(1)
+			return ErrNotMetric
+		}
+		lr.add(*sample)
+	default:
+		metricErrVar.Add(1)
+		return ErrNotMetric
+	}
+	return nil
+}
+
+func (lr *LinearRegression) Export() (interface{}, error) {
+	exported := *lr
+	return &exported, nil
+}
+
+func (lr *LinearRegression) MergeFrom(other interface{}) error {
+	o, ok := other.(*LinearRegression)
+	if !ok {
+		return ErrNotMergeable
+	}
+	if o.Count == 0 {
+		return nil
+	}
+	if lr.Count == 0 {
+		*lr = *o
+		return nil
+	}
+	n := lr.Count + o.Count
+	deltaX := o.MeanX - lr.MeanX
+	deltaY := o.MeanY - lr.MeanY
+	factor := lr.Count * o.Count / n
+	lr.M2X += o.M2X + deltaX*deltaX*factor
+	lr.M2Y += o.M2Y + deltaY*deltaY*factor
+	lr.CXY += o.CXY + deltaX*deltaY*factor
+	lr.MeanX += deltaX * o.Count / n
+	lr.MeanY += deltaY * o.Count / n
+	lr.Count = n
+	return nil
+}
+
+// Returns LinearFit, or ErrSingularFit when there are less than 2 samples or
+// all X are equal, slope is undefined then.
+func (lr *LinearRegression) Result(ctx context.Context) (interface{}, error) {
+	if lr.Count < 2 || lr.M2X == 0 {
+		return LinearFit{}, ErrSingularFit
+	}
+	slope := lr.CXY / lr.M2X
+	fit := LinearFit{
+		Slope:     slope,
+		Intercept: lr.MeanY - slope*lr.MeanX,
+		R2:        1.0,
+	}
+	if lr.M2Y > 0 {
+		fit.R2 = lr.CXY * lr.CXY / (lr.M2X * lr.M2Y)
+	}
+	return fit, nil
+}
@@ -0,0 +1,154 @@
+package aggregator
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+type TopKEntry struct {
+	Label saw.DatumKey
+	Score Metric
+}
+
+// Min-heap of entries by score, with position of each label.
+type topKHeap struct {
+	entries []TopKEntry
+	index   map[saw.DatumKey]int
+}
+
+func (h *topKHeap) Len() int           { return len(h.entries) }
+func (h *topKHeap) Less(i, j int) bool { return h.entries[i].Score < h.entries[j].Score }
+
+func (h *topKHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.index[h.entries[i].Label] = i
+	h.index[h.entries[j].Label] = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	entry := x.(TopKEntry)
+	h.index[entry.Label] = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	delete(h.index, last.Label)
+	return last
+}
+
+// GlobalTopK aggregator saw keeps top K labels by score across all keys, for
+// leaderboards where labels recur and scores change. Emit() takes datum.Key as
+// label and numeric datum.Value (see ToMetric()) as its latest score, which
+// replaces the previous score of the label.
+//
+// Only labels currently in top K are remembered. When a label's score drops,
+// it stays ranked by the new score, but labels evicted earlier are not brought
+// back until they are emitted again. Emit() is concurrent safe, it can be
+// subscribed to a Hub topic directly.
+type GlobalTopK struct {
+	mu   sync.Mutex
+	k    int
+	heap topKHeap
+}
+
+func NewGlobalTopK(k int) *GlobalTopK {
+	return &GlobalTopK{
+		k:    k,
+		heap: topKHeap{index: make(map[saw.DatumKey]int)},
+	}
+}
+
+// Sets score of label, replace when keepMax is false, otherwise keeps the
+// larger one.
+func (tk *GlobalTopK) update(label saw.DatumKey, score Metric, keepMax bool) {
+	if idx, ok := tk.heap.index[label]; ok {
+		if keepMax && score <= tk.heap.entries[idx].Score {
+			return
+		}
+		tk.heap.entries[idx].Score = score
+		heap.Fix(&tk.heap, idx)
+		return
+	}
+	if tk.heap.Len() < tk.k {
+		heap.Push(&tk.heap, TopKEntry{Label: label, Score: score})
+		return
+	}
+	if tk.k <= 0 || score <= tk.heap.entries[0].Score {
+		return
+	}
+	delete(tk.heap.index, tk.heap.entries[0].Label)
+	tk.heap.entries[0] = TopKEntry{Label: label, Score: score}
+	tk.heap.index[label] = 0
+	heap.Fix(&tk.heap, 0)
+}
+
+func (tk *GlobalTopK) Emit(datum saw.Datum) error {
+	score, err := datumMetric(datum)
+	if err != nil {
+		return err
+	}
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	tk.update(datum.Key, score, false)
+	return nil
+}
+
+// TopKExport is Export() of GlobalTopK, entries are in no particular order.
+type TopKExport struct {
+	K       int
+	Entries []TopKEntry
+}
+
+func (tk *GlobalTopK) Export() (interface{}, error) {
+	return &TopKExport{K: tk.k, Entries: tk.entries()}, nil
+}
+
+// MergeFrom merges top K of other GlobalTopK or its Export(), a label in both
+// keeps the larger score as merge order is unknown.
+func (tk *GlobalTopK) MergeFrom(other interface{}) error {
+	var entries []TopKEntry
+	switch o := other.(type) {
+	case *GlobalTopK:
+		entries = o.entries()
+	case *TopKExport:
+		entries = o.Entries
+	default:
+		return ErrNotMergeable
+	}
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	for _, entry := range entries {
+		tk.update(entry.Label, entry.Score, true)
+	}
+	return nil
+}
+
+func (tk *GlobalTopK) entries() []TopKEntry {
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	return append([]TopKEntry(nil), tk.heap.entries...)
+}
+
+type topKEntrySort []TopKEntry
+
+func (ts topKEntrySort) Len() int { return len(ts) }
+func (ts topKEntrySort) Less(i, j int) bool {
+	if ts[i].Score != ts[j].Score {
+		return ts[i].Score > ts[j].Score
+	}
+	return ts[i].Label < ts[j].Label
+}
+func (ts topKEntrySort) Swap(i, j int) { ts[i], ts[j] = ts[j], ts[i] }
+
+// Returns []TopKEntry by score descending, ties by label.
+func (tk *GlobalTopK) Result(ctx context.Context) (interface{}, error) {
+	entries := tk.entries()
+	sort.Sort(topKEntrySort(entries))
+	return entries, nil
+}
@@ -0,0 +1,113 @@
+package saw
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Batcher is a saw buffers datums and flushes them in batches, when size of
+// datums accumulated, or every flush interval, e.g. for bulk insert into a
+// database.
+//
+// Flushes are serialized, Emit() blocks while a flush is ongoing.
+type Batcher struct {
+	size    int
+	onFlush func([]Datum) error
+
+	mu     sync.Mutex
+	buffer []Datum
+	// Error of flushes triggered by timer, returned by Result().
+	tickErr error
+	// Set by first Result().
+	stopped bool
+
+	ticker *time.Ticker
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewBatcher creates a Batcher that calls onFlush with every size datums, and
+// with datums buffered every flush interval if flush > 0. onFlush must not
+// retain the slice passed in.
+func NewBatcher(size int, flush time.Duration, onFlush func([]Datum) error) *Batcher {
+	batcher := &Batcher{
+		size:    size,
+		onFlush: onFlush,
+		buffer:  make([]Datum, 0, size),
+		done:    make(chan struct{}),
+	}
+	if flush > 0 {
+		batcher.ticker = time.NewTicker(flush)
+		batcher.stop = make(chan struct{})
+		go batcher.tick()
+	} else {
+		close(batcher.done)
+	}
+	return batcher
+}
+
+func (batcher *Batcher) tick() {
+	defer close(batcher.done)
+	for {
+		select {
+		case <-batcher.stop:
+			return
+		case <-batcher.ticker.C:
+			batcher.mu.Lock()
+			if err := batcher.flush(); err != nil && batcher.tickErr == nil {
+				batcher.tickErr = err
+			}
+			batcher.mu.Unlock()
+		}
+	}
+}
+
+// Called with mu held.
+func (batcher *Batcher) flush() error {
+	if len(batcher.buffer) == 0 {
+		return nil
+	}
+	err := batcher.onFlush(batcher.buffer)
+	batcher.buffer = batcher.buffer[:0]
+	return err
+}
+
+// Emit buffers datum, returns error of onFlush if it triggers a flush.
+func (batcher *Batcher) Emit(datum Datum) error {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+
+	batcher.buffer = append(batcher.buffer, datum)
+	if len(batcher.buffer) >= batcher.size {
+		return batcher.flush()
+	}
+	return nil
+}
+
+// Result stops flush timer and flushes datums remaining, returns nil result,
+// error of the final flush, or the first error of timer triggered flushes.
+// Batcher can not be emitted to after Result(), calls of Result() after the
+// first one return nil.
+func (batcher *Batcher) Result(ctx context.Context) (interface{}, error) {
+	batcher.mu.Lock()
+	stopped := batcher.stopped
+	batcher.stopped = true
+	batcher.mu.Unlock()
+	if stopped {
+		return nil, nil
+	}
+	if batcher.ticker != nil {
+		batcher.ticker.Stop()
+		close(batcher.stop)
+	}
+	<-batcher.done
+
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	if err := batcher.flush(); err != nil {
+		return nil, err
+	}
+	return nil, batcher.tickErr
+}
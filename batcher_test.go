@@ -0,0 +1,29 @@
+package saw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Result() flushes once, repeated calls return nil instead of panic.
+func TestBatcherResultTwice(t *testing.T) {
+	errFlush := errors.New("flush failed")
+	flushes := 0
+	batcher := NewBatcher(10, time.Hour, func(datums []Datum) error {
+		flushes++
+		return errFlush
+	})
+	batcher.Emit(Datum{Key: "a"})
+	if _, err := batcher.Result(context.Background()); err != errFlush {
+		t.Errorf("Result() returns %v, want %v", err, errFlush)
+	}
+	if _, err := batcher.Result(context.Background()); err != nil {
+		t.Errorf("second Result() returns %v, want nil", err)
+	}
+	if flushes != 1 {
+		t.Errorf("flushed %d times, want once", flushes)
+	}
+}
@@ -0,0 +1,76 @@
+package saw
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// Pipe is a stage of Chain that transforms datum and forwards it to the next
+// stage.
+type Pipe interface {
+	// Returns datum to forward to the next stage, forward=false drops the
+	// datum, err stops it and is returned by Chain's Emit().
+	Pipe(input Datum) (output Datum, forward bool, err error)
+}
+
+// PipeFunc is a stateless Pipe, it can be used as a non-terminal stage of Chain.
+type PipeFunc func(input Datum) (output Datum, forward bool, err error)
+
+func (pf PipeFunc) Pipe(input Datum) (Datum, bool, error) {
+	return pf(input)
+}
+
+// Emit runs pipe and discards output.
+func (pf PipeFunc) Emit(datum Datum) error {
+	_, _, err := pf(datum)
+	return err
+}
+
+func (pf PipeFunc) Result(ctx context.Context) (interface{}, error) {
+	return nil, nil
+}
+
+type chainSaw struct {
+	pipes    []Pipe
+	terminal Saw
+}
+
+func (cs *chainSaw) Emit(datum Datum) error {
+	for _, pipe := range cs.pipes {
+		var forward bool
+		var err error
+		if datum, forward, err = pipe.Pipe(datum); err != nil || !forward {
+			return err
+		}
+	}
+	return cs.terminal.Emit(datum)
+}
+
+// Result returns Result() of the terminal stage, Result() of other stages are
+// not called.
+func (cs *chainSaw) Result(ctx context.Context) (interface{}, error) {
+	return cs.terminal.Result(ctx)
+}
+
+// Chain composes stages into a linear pipeline as a single saw, a lightweight
+// alternative to Hub.
+//
+// All stages but the last must implement Pipe, Emit() passes datum through
+// Pipe() of them in order, each gets output of the previous one, then Emit()
+// it to the last stage, unless a stage returns error or drops the datum.
+// Panics if stages is empty or non-terminal stage is not a Pipe.
+func Chain(stages ...Saw) Saw {
+	if len(stages) == 0 {
+		panic("saw: Chain requires at least one stage")
+	}
+	pipes := make([]Pipe, len(stages)-1)
+	for i, stage := range stages[:len(stages)-1] {
+		pipe, ok := stage.(Pipe)
+		if !ok {
+			panic(fmt.Sprintf("saw: Chain stage %d of type %T is not a Pipe", i, stage))
+		}
+		pipes[i] = pipe
+	}
+	return &chainSaw{pipes: pipes, terminal: stages[len(stages)-1]}
+}
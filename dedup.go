@@ -0,0 +1,68 @@
+package saw
+
+import (
+	"container/list"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Dedup is a saw forwards datum to its downstream only the first time its Key
+// is seen.
+//
+// Seen keys are kept in a LRU of window keys, so dedup is exact within the
+// window: a key is suppressed as long as it's among the window most recently
+// seen keys, while a key evicted from LRU is forwarded again when it shows up.
+// Memory is bounded by window, choose window larger than span of duplicates
+// expected. # of duplicated datums not forwarded are reported under name as
+// suppressed.
+type Dedup struct {
+	downstream    Saw
+	window        int
+	suppressedVar VarInt
+
+	mu   sync.Mutex
+	lru  *list.List
+	keys map[DatumKey]*list.Element
+}
+
+func NewDedup(name string, downstream Saw, window int) *Dedup {
+	return &Dedup{
+		downstream:    downstream,
+		window:        window,
+		suppressedVar: ReportInt(name, "suppressed"),
+		lru:           list.New(),
+		keys:          make(map[DatumKey]*list.Element),
+	}
+}
+
+// Returns true if key is seen in window, marks it as most recently seen.
+func (dedup *Dedup) seen(key DatumKey) bool {
+	dedup.mu.Lock()
+	defer dedup.mu.Unlock()
+
+	if elem, ok := dedup.keys[key]; ok {
+		dedup.lru.MoveToFront(elem)
+		return true
+	}
+	dedup.keys[key] = dedup.lru.PushFront(key)
+	if dedup.lru.Len() > dedup.window {
+		oldest := dedup.lru.Back()
+		dedup.lru.Remove(oldest)
+		delete(dedup.keys, oldest.Value.(DatumKey))
+	}
+	return false
+}
+
+func (dedup *Dedup) Emit(datum Datum) error {
+	if dedup.seen(datum.Key) {
+		dedup.suppressedVar.Add(1)
+		return nil
+	}
+	return dedup.downstream.Emit(datum)
+}
+
+// Result returns result of downstream.
+func (dedup *Dedup) Result(ctx context.Context) (interface{}, error) {
+	return dedup.downstream.Result(ctx)
+}
@@ -2,12 +2,20 @@ package saw
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"reflect"
 
+	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 )
 
+var ErrCiphertextTooShort = errors.New("saw: ciphertext too short")
+
 type ValueEncoder interface {
 	EncodeValue(value interface{}, buf []byte) ([]byte, error)
 }
@@ -73,3 +81,114 @@ func NewProtoDecoder(example interface{}) ProtoDecoder {
 		ValueType: reflect.TypeOf(example).Elem(),
 	}
 }
+
+// ProtoJSONEncoder encodes proto.Message in canonical protobuf JSON, human
+// readable counterpart of ProtoEncoder.
+type ProtoJSONEncoder struct {
+	Marshaler jsonpb.Marshaler
+}
+
+func (pje ProtoJSONEncoder) EncodeValue(value interface{}, buf []byte) ([]byte, error) {
+	w := bytes.NewBuffer(buf)
+	w.Reset()
+	if err := pje.Marshaler.Marshal(w, value.(proto.Message)); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+type ProtoJSONDecoder struct {
+	ValueType reflect.Type
+}
+
+func (pjd ProtoJSONDecoder) DecodeValue(buf []byte) (interface{}, error) {
+	message := reflect.New(pjd.ValueType).Interface().(proto.Message)
+	if err := jsonpb.Unmarshal(bytes.NewReader(buf), message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func NewProtoJSONDecoder(example interface{}) ProtoJSONDecoder {
+	return ProtoJSONDecoder{
+		ValueType: reflect.TypeOf(example).Elem(),
+	}
+}
+
+// Base64Codec wraps an inner codec, encodes its output in base64, so that binary
+// values can be stored in line based formats like textio. Either Encoder or
+// Decoder can be nil when only the other direction is used. Trailing newline of
+// value read by textio is ignored in decoding.
+type Base64Codec struct {
+	Encoder ValueEncoder
+	Decoder ValueDecoder
+}
+
+func (bc Base64Codec) EncodeValue(value interface{}, buf []byte) ([]byte, error) {
+	raw, err := bc.Encoder.EncodeValue(value, buf)
+	if err != nil {
+		return nil, err
+	}
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(raw)))
+	base64.StdEncoding.Encode(encoded, raw)
+	return encoded, nil
+}
+
+func (bc Base64Codec) DecodeValue(buf []byte) (interface{}, error) {
+	raw := make([]byte, base64.StdEncoding.DecodedLen(len(buf)))
+	n, err := base64.StdEncoding.Decode(raw, bytes.TrimRight(buf, "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	return bc.Decoder.DecodeValue(raw[:n])
+}
+
+// AESCodec wraps an inner codec, encrypts its output with AES-GCM. A random
+// nonce is generated for every value and prepended to the sealed bytes, values
+// not encrypted by the same key, or tampered, fail in DecodeValue() for auth tag
+// mismatch. Either Encoder or Decoder can be nil when only the other direction
+// is used.
+type AESCodec struct {
+	Encoder ValueEncoder
+	Decoder ValueDecoder
+	aead    cipher.AEAD
+}
+
+// NewAESCodec creates AESCodec, key must be 16, 24 or 32 bytes, for AES-128,
+// AES-192 or AES-256.
+func NewAESCodec(key []byte, encoder ValueEncoder, decoder ValueDecoder) (AESCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return AESCodec{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return AESCodec{}, err
+	}
+	return AESCodec{Encoder: encoder, Decoder: decoder, aead: aead}, nil
+}
+
+func (ac AESCodec) EncodeValue(value interface{}, buf []byte) ([]byte, error) {
+	plain, err := ac.Encoder.EncodeValue(value, buf)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := ac.aead.NonceSize()
+	sealed := make([]byte, nonceSize, nonceSize+len(plain)+ac.aead.Overhead())
+	if _, err := rand.Read(sealed); err != nil {
+		return nil, err
+	}
+	return ac.aead.Seal(sealed, sealed, plain, nil), nil
+}
+
+func (ac AESCodec) DecodeValue(buf []byte) (interface{}, error) {
+	nonceSize := ac.aead.NonceSize()
+	if len(buf) < nonceSize {
+		return nil, ErrCiphertextTooShort
+	}
+	plain, err := ac.aead.Open(nil, buf[:nonceSize], buf[nonceSize:], nil)
+	if err != nil {
+		return nil, err
+	}
+	return ac.Decoder.DecodeValue(plain)
+}
@@ -27,10 +27,7 @@ type YelpHandler struct{}
 
 func (yh *YelpHandler) Emit(datum saw.Datum) error {
 	review := datum.Value.(*YelpReview)
-	bizSumTable.Emit(saw.Datum{
-		Key:   saw.DatumKey(review.BizId),
-		Value: aggregator.Metric(1),
-	})
+	bizSumTable.Emit(saw.Datum{Key: saw.DatumKey(review.BizId)})
 	// for i := 0; i < 5; i++ {
 	reviewByUserTable.Emit(saw.Datum{
 		Key:   saw.DatumKey(review.UserId),
@@ -66,7 +63,7 @@ func init() {
 	bizSumTable = table.NewMemTable(table.TableSpec{
 		Name:               "bizSumTable",
 		PersistentResource: bizSumTableOutput,
-		ItemFactory:        table.ItemFactoryOf(&aggregator.Sum{}),
+		ItemFactory:        table.ItemFactoryOf(&aggregator.Count{}),
 		ValueEncoder:       saw.JSONEncoder{},
 	})
 
@@ -95,7 +92,9 @@ func main() {
 	}
 
 	startTime := time.Now()
-	runner.RunBatch(batch)
+	if err := runner.RunBatch(batch); err != nil {
+		log.Panic(err)
+	}
 	fmt.Println("Done", time.Since(startTime))
 
 	result, err := bizSumTable.Result(context.Background())
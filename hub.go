@@ -1,7 +1,20 @@
 package saw
 
 import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrNoSubscriber   = errors.New("saw: topic has no subscriber")
+	ErrTopicValueType = errors.New("saw: datum value type mismatches topic")
 )
 
 type TopicID string
@@ -10,24 +23,44 @@ type topic struct {
 	id          TopicID
 	subscribers []Saw
 	countVar    VarInt
+	errVar      VarInt
+	// Points to Hub.timed, times subscribers when non-zero.
+	timed    *int32
+	timerVar VarTimer
 }
 
-func newTopic(varPrefix string, id TopicID) *topic {
+func (hub *Hub) newTopic(id TopicID) *topic {
 	return &topic{
 		id:       id,
-		countVar: ReportInt(varPrefix+"."+string(id), "count"),
+		countVar: ReportInt(hub.varPrefix+"."+string(id), "count"),
+		errVar:   ReportInt(hub.varPrefix+"."+string(id), "errors"),
+		timed:    &hub.timed,
+		timerVar: ReportTimer(hub.varPrefix+"."+string(id), "latency"),
 	}
 }
 
-func (t *topic) addSubscriber(saw Saw) {
-	t.subscribers = append(t.subscribers, saw)
-}
-
-func (t *topic) emit(datum Datum) {
+// Emits to all subscribers even some of them fail, returns the first error.
+func (t *topic) emit(ctx context.Context, datum Datum) error {
+	var firstErr error
+	timed := atomic.LoadInt32(t.timed) != 0
 	for _, saw := range t.subscribers {
-		saw.Emit(datum)
+		var start time.Time
+		if timed {
+			start = time.Now()
+		}
+		err := EmitContext(ctx, saw, datum)
+		if timed {
+			t.timerVar.Observe(time.Since(start))
+		}
+		if err != nil {
+			t.errVar.Add(1)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
 	}
 	t.countVar.Add(1)
+	return firstErr
 }
 
 // Hub is a simple pubsub to allow loosely coupled communication between saws
@@ -35,20 +68,56 @@ func (t *topic) emit(datum Datum) {
 //
 // It's a simple local, sync implementation only for better pipeline program structure,
 // and it should keep it as it is. parallel, async computing, should be addressed
-// by Queues and Pars, implemented by each individual Saw.
+// by Queues and Pars, implemented by each individual Saw, see runner.Queue and
+// runner.Par, or runner.AsyncHub which queues datums per topic.
 type Hub struct {
-	varPrefix     string
-	mu            sync.Mutex
-	topics        map[TopicID]*topic
+	varPrefix string
+	mu        sync.Mutex
+	// *hubState, copy-on-write under mu so that Publish() is lock free.
+	state         atomic.Value
 	deadLetterVar VarInt
+	timed         int32
+}
+
+// Snapshot of subscriptions in Hub, never modified once stored, nor topics in
+// it.
+type hubState struct {
+	topics map[TopicID]*topic
+	// Prefix subscriptions, in order of first registration, topic id is the
+	// prefix.
+	prefixes []*topic
+	// Declared value types of topics, by RegisterTyped().
+	types map[TopicID]reflect.Type
 }
 
 func NewHub(varPrefix string) *Hub {
-	return &Hub{
+	hub := &Hub{
 		varPrefix:     varPrefix,
-		topics:        make(map[TopicID]*topic),
 		deadLetterVar: ReportInt(varPrefix+".DEAD", "count"),
 	}
+	hub.state.Store(&hubState{topics: make(map[TopicID]*topic)})
+	return hub
+}
+
+func (hub *Hub) loadState() *hubState {
+	return hub.state.Load().(*hubState)
+}
+
+// Copies current state, called with mu held.
+func (hub *Hub) copyState() *hubState {
+	curr := hub.loadState()
+	state := &hubState{
+		topics:   make(map[TopicID]*topic),
+		prefixes: append([]*topic(nil), curr.prefixes...),
+		types:    make(map[TopicID]reflect.Type),
+	}
+	for id, typ := range curr.types {
+		state.types[id] = typ
+	}
+	for id, t := range curr.topics {
+		state.topics[id] = t
+	}
+	return state
 }
 
 // Register saw that subscribes to a list of Topic
@@ -56,26 +125,199 @@ func (hub *Hub) Register(saw Saw, subscribes ...TopicID) {
 	hub.mu.Lock()
 	defer hub.mu.Unlock()
 
+	state := hub.copyState()
 	for _, topicID := range subscribes {
-		topic, ok := hub.topics[topicID]
+		updated := hub.newTopic(topicID)
+		if curr, ok := state.topics[topicID]; ok {
+			updated.subscribers = append(updated.subscribers, curr.subscribers...)
+		}
+		updated.subscribers = append(updated.subscribers, saw)
+		state.topics[topicID] = updated
+	}
+	hub.state.Store(state)
+}
+
+// RegisterPrefix registers saw that subscribes to all topics whose id starts
+// with one of prefixes, e.g. "events." for "events.click" and "events.view".
+//
+// On Publish(), exact subscribers of the topic receive the datum first, then
+// prefix subscribers, in order of the prefix first registered.
+func (hub *Hub) RegisterPrefix(saw Saw, prefixes ...string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	state := hub.copyState()
+	for _, prefix := range prefixes {
+		updated := hub.newTopic(TopicID(prefix + "*"))
+		updated.id = TopicID(prefix)
+		found := false
+		for i, curr := range state.prefixes {
+			if curr.id == updated.id {
+				updated.subscribers = append(updated.subscribers, curr.subscribers...)
+				updated.subscribers = append(updated.subscribers, saw)
+				state.prefixes[i] = updated
+				found = true
+				break
+			}
+		}
+		if !found {
+			updated.subscribers = append(updated.subscribers, saw)
+			state.prefixes = append(state.prefixes, updated)
+		}
+	}
+	hub.state.Store(state)
+}
+
+// Unregister removes saw from subscribers of topics, if saw was registered to a
+// topic multiple times, all of them are removed. Topics end up with no
+// subscriber are removed, publishing to them counts as dead letter. Prefix
+// subscriptions are not affected.
+//
+// Saws are matched by identity (==), dynamic type of saw must be comparable,
+// normally a pointer.
+func (hub *Hub) Unregister(saw Saw, topics ...TopicID) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	state := hub.copyState()
+	for _, topicID := range topics {
+		curr, ok := state.topics[topicID]
 		if !ok {
-			topic = newTopic(hub.varPrefix, topicID)
-			hub.topics[topicID] = topic
+			continue
 		}
-		topic.addSubscriber(saw)
+		updated := hub.newTopic(topicID)
+		for _, subscriber := range curr.subscribers {
+			if subscriber != saw {
+				updated.subscribers = append(updated.subscribers, subscriber)
+			}
+		}
+		if len(updated.subscribers) == 0 {
+			delete(state.topics, topicID)
+		} else {
+			state.topics[topicID] = updated
+		}
+	}
+	hub.state.Store(state)
+}
+
+// TimeSubscribers enables or disables timing of each subscriber's Emit(),
+// reported in topic's latency var. Disabled by default.
+func (hub *Hub) TimeSubscribers(enabled bool) {
+	var timed int32
+	if enabled {
+		timed = 1
+	}
+	atomic.StoreInt32(&hub.timed, timed)
+}
+
+// RegisterTyped declares type of datum.Value published to topic id, values not
+// of typ, or not implementing typ if it's an interface type, are dropped on
+// Publish() and counted as dead letter, rather than panic in subscribers'
+// type assertions. Nil values always mismatch.
+//
+// Prefix subscribers only get checked by type of the exact topic published to.
+func RegisterTyped(hub *Hub, id TopicID, typ reflect.Type) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	state := hub.copyState()
+	state.types[id] = typ
+	hub.state.Store(state)
+}
+
+func typeMatches(typ reflect.Type, value interface{}) bool {
+	valueType := reflect.TypeOf(value)
+	if valueType == nil {
+		return false
+	}
+	if typ.Kind() == reflect.Interface {
+		return valueType.Implements(typ)
+	}
+	return valueType == typ
+}
+
+// Topics returns ids of topics having exact subscribers, in sorted order.
+func (hub *Hub) Topics() []TopicID {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	state := hub.loadState()
+	ids := make([]TopicID, 0, len(state.topics))
+	for id := range state.topics {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// SubscriberCount returns number of exact subscriptions to topic, a saw
+// registered multiple times counts multiple times. Prefix subscriptions are not
+// counted.
+func (hub *Hub) SubscriberCount(id TopicID) int {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if topic, ok := hub.loadState().topics[id]; ok {
+		return len(topic.subscribers)
 	}
+	return 0
 }
 
 // Publish to topic, resulting in emit to all saws subscirbed in sequence.
 // Concurrent calls to Publish() are not synchonized, subscribers is expected
-// to handle concurrent Emit()
+// to handle concurrent Emit(). Publish() concurrent with Register() or
+// Unregister() sees subscribers either before or after the change.
+//
+// Subscriber errors are ignored, only counted in topic's errors var.
 func (hub *Hub) Publish(id TopicID, datum Datum) {
-	topic, ok := hub.topics[id]
+	hub.PublishChecked(id, datum)
+}
+
+// PublishChecked is like Publish, but returns the first error subscribers
+// returns, all subscribers still receive the datum. ErrNoSubscriber returns
+// if no saw subscribes to the topic, ErrTopicValueType if datum.Value does not
+// match type declared by RegisterTyped().
+func (hub *Hub) PublishChecked(id TopicID, datum Datum) error {
+	return hub.PublishContext(context.Background(), id, datum)
+}
+
+// PublishContext is like PublishChecked, with ctx passed to subscribers
+// implementing EmitContextSaw.
+func (hub *Hub) PublishContext(ctx context.Context, id TopicID, datum Datum) error {
+	state := hub.loadState()
+	if len(state.types) > 0 {
+		if typ, ok := state.types[id]; ok && !typeMatches(typ, datum.Value) {
+			hub.deadLetterVar.Add(1)
+			return ErrTopicValueType
+		}
+	}
+	topic, ok := state.topics[id]
+	if len(state.prefixes) == 0 {
+		if !ok {
+			hub.deadLetterVar.Add(1)
+			return ErrNoSubscriber
+		}
+		return topic.emit(ctx, datum)
+	}
+
+	var firstErr error
+	if ok {
+		firstErr = topic.emit(ctx, datum)
+	}
+	for _, prefix := range state.prefixes {
+		if !strings.HasPrefix(string(id), string(prefix.id)) {
+			continue
+		}
+		ok = true
+		if err := prefix.emit(ctx, datum); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 	if !ok {
 		hub.deadLetterVar.Add(1)
-		return
+		return ErrNoSubscriber
 	}
-	topic.emit(datum)
+	return firstErr
 }
 
 var GlobalHub = NewHub("global")
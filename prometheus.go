@@ -0,0 +1,118 @@
+package saw
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// PrometheusHandler serves vars reported by Report*() in Prometheus text
+// format, expvar stays the source of truth.
+//
+// Var ns.name becomes metric saw_<name> with label ns="<ns>", e.g.
+// global.events.click.count is saw_count{ns="global.events.click"}, so that
+// same kind of vars are grouped into one metric. Map vars get an additional
+// label, timers become histograms. String vars are not exported.
+func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(prometheusText())
+	})
+}
+
+type promMetric struct {
+	kind    string
+	samples []string
+}
+
+func prometheusText() []byte {
+	varLock.Lock()
+	vars := make([]reportedVar, 0, len(reportedVars))
+	for _, rv := range reportedVars {
+		vars = append(vars, rv)
+	}
+	varLock.Unlock()
+
+	metrics := make(map[string]*promMetric)
+	add := func(name, kind, sample string) {
+		metric, ok := metrics[name]
+		if !ok {
+			metric = &promMetric{kind: kind}
+			metrics[name] = metric
+		}
+		metric.samples = append(metric.samples, sample)
+	}
+	for _, rv := range vars {
+		name := "saw_" + promName(rv.name)
+		ns := `ns="` + promEscape(rv.ns) + `"`
+		switch v := rv.v.(type) {
+		case *expvar.Int:
+			add(name, "untyped", fmt.Sprintf("%s{%s} %d", name, ns, v.Value()))
+		case *expvar.Float:
+			add(name, "untyped", fmt.Sprintf("%s{%s} %s", name, ns, promFloat(v.Value())))
+		case *expvar.Map:
+			v.Do(func(kv expvar.KeyValue) {
+				add(name, "untyped", fmt.Sprintf(
+					"%s{%s,label=\"%s\"} %s", name, ns, promEscape(kv.Key), kv.Value.String()))
+			})
+		case *timerVar:
+			var cumulative int64
+			for i := range v.buckets {
+				le := "+Inf"
+				if i < len(timerBuckets) {
+					le = promFloat(timerBuckets[i].Seconds())
+				}
+				cumulative += atomic.LoadInt64(&v.buckets[i])
+				add(name, "histogram", fmt.Sprintf(
+					"%s_bucket{%s,le=\"%s\"} %d", name, ns, le, cumulative))
+			}
+			add(name, "histogram", fmt.Sprintf(
+				"%s_sum{%s} %s", name, ns, promFloat(float64(atomic.LoadInt64(&v.sumNano))/1e9)))
+			add(name, "histogram", fmt.Sprintf(
+				"%s_count{%s} %d", name, ns, atomic.LoadInt64(&v.count)))
+		}
+	}
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		metric := metrics[name]
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, metric.kind)
+		if metric.kind != "histogram" {
+			sort.Strings(metric.samples)
+		}
+		for _, sample := range metric.samples {
+			buf.WriteString(sample)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// Replaces characters not allowed in Prometheus metric names with '_'.
+func promName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func promEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func promFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
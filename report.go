@@ -1,8 +1,13 @@
 package saw
 
 import (
+	"encoding/json"
 	"expvar"
+	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type VarInt interface {
@@ -15,31 +20,200 @@ type VarFloat interface {
 	Set(value float64)
 }
 
+type VarString interface {
+	Set(value string)
+}
+
+// VarMap is a set of int counters under labels.
+type VarMap interface {
+	AddLabeled(label string, delta int64)
+}
+
+// VarTimer tracks count, sum and histogram of durations.
+type VarTimer interface {
+	Observe(d time.Duration)
+}
+
+// Upper bounds of timer histogram buckets, last bucket is unbounded.
+var timerBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// expvar.Var of VarTimer, shown as JSON object of count, sum in seconds, and
+// buckets of counts keyed by upper bound, "+Inf" for the last one.
+type timerVar struct {
+	count   int64
+	sumNano int64
+	buckets []int64
+}
+
+func newTimerVar() *timerVar {
+	return &timerVar{buckets: make([]int64, len(timerBuckets)+1)}
+}
+
+func (tv *timerVar) Observe(d time.Duration) {
+	idx := len(timerBuckets)
+	for i, bound := range timerBuckets {
+		if d <= bound {
+			idx = i
+			break
+		}
+	}
+	atomic.AddInt64(&tv.buckets[idx], 1)
+	atomic.AddInt64(&tv.sumNano, int64(d))
+	atomic.AddInt64(&tv.count, 1)
+}
+
+func (tv *timerVar) String() string {
+	buckets := make(map[string]int64)
+	for i := range tv.buckets {
+		label := "+Inf"
+		if i < len(timerBuckets) {
+			label = timerBuckets[i].String()
+		}
+		buckets[label] = atomic.LoadInt64(&tv.buckets[i])
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"count":   atomic.LoadInt64(&tv.count),
+		"sum":     time.Duration(atomic.LoadInt64(&tv.sumNano)).Seconds(),
+		"buckets": buckets,
+	})
+	return string(data)
+}
+
+type varMap struct {
+	m *expvar.Map
+}
+
+func (vm varMap) AddLabeled(label string, delta int64) {
+	vm.m.Add(label, delta)
+}
+
 var varLock sync.Mutex
 
+type reportedVar struct {
+	ns, name string
+	v        expvar.Var
+}
+
+// Vars created or fetched by Report*(), by full name, to tell saw vars from
+// other expvars.
+var reportedVars = make(map[string]reportedVar)
+
+// Fetches var ns.name, or creates it by create, and records it as reported.
+func reportVar(ns, name string, create func(varName string) expvar.Var) expvar.Var {
+	varName := ns + "." + name
+	varLock.Lock()
+	defer varLock.Unlock()
+
+	v := expvar.Get(varName)
+	if v == nil {
+		v = create(varName)
+	}
+	reportedVars[varName] = reportedVar{ns: ns, name: name, v: v}
+	return v
+}
+
 // Creates or fetches a int var for reporting, unlike its underling expvar,
 // ReportInt is expected to called when saws are dynamically created, in
 // TableItemFactory etc, so that or saws inside a single table can shares same
 // reporting metric.
 func ReportInt(ns, name string) VarInt {
-	varName := ns + "." + name
+	return reportVar(ns, name, func(varName string) expvar.Var {
+		return expvar.NewInt(varName)
+	}).(*expvar.Int)
+}
+
+// Creates float var for reporting. see ReportInt() for usage detail.
+func ReportFloat(ns, name string) VarFloat {
+	return reportVar(ns, name, func(varName string) expvar.Var {
+		return expvar.NewFloat(varName)
+	}).(*expvar.Float)
+}
+
+// Creates string var for reporting, e.g. last error or current state name. see
+// ReportInt() for usage detail.
+func ReportString(ns, name string) VarString {
+	return reportVar(ns, name, func(varName string) expvar.Var {
+		return expvar.NewString(varName)
+	}).(*expvar.String)
+}
+
+// Creates a map var of labeled int counters for reporting, e.g. per-shard
+// sizes. see ReportInt() for usage detail.
+func ReportMap(ns, name string) VarMap {
+	return varMap{reportVar(ns, name, func(varName string) expvar.Var {
+		return expvar.NewMap(varName)
+	}).(*expvar.Map)}
+}
+
+// Creates timer var for reporting latencies. see ReportInt() for usage detail.
+func ReportTimer(ns, name string) VarTimer {
+	return reportVar(ns, name, func(varName string) expvar.Var {
+		tv := newTimerVar()
+		expvar.Publish(varName, tv)
+		return tv
+	}).(*timerVar)
+}
+
+// SnapshotVars returns current values of all vars reported by Report*(), keyed
+// by full var name, values are decoded from their expvar JSON form.
+func SnapshotVars() map[string]interface{} {
 	varLock.Lock()
 	defer varLock.Unlock()
 
-	if v := expvar.Get(varName); v != nil {
-		return v.(*expvar.Int)
+	snapshot := make(map[string]interface{}, len(reportedVars))
+	for varName, rv := range reportedVars {
+		var value interface{}
+		if err := json.Unmarshal([]byte(rv.v.String()), &value); err != nil {
+			value = rv.v.String()
+		}
+		snapshot[varName] = value
 	}
-	return expvar.NewInt(varName)
+	return snapshot
 }
 
-// Creates float var for reporting. see ReportInt() for usage detail.
-func ReportFloat(ns, name string) VarFloat {
-	varName := ns + "." + name
+// WriteVarsJSON writes SnapshotVars() to w as a JSON object, e.g. to record
+// final counters of a batch job alongside its results.
+func WriteVarsJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(SnapshotVars())
+}
+
+func (tv *timerVar) reset() {
+	for i := range tv.buckets {
+		atomic.StoreInt64(&tv.buckets[i], 0)
+	}
+	atomic.StoreInt64(&tv.sumNano, 0)
+	atomic.StoreInt64(&tv.count, 0)
+}
+
+// ResetVars zeroes all vars reported by Report*() whose full name starts with
+// prefix, so that runs in one process can each start with clean counters. Vars
+// keep being shared by saws holding them.
+func ResetVars(prefix string) {
 	varLock.Lock()
 	defer varLock.Unlock()
 
-	if v := expvar.Get(varName); v != nil {
-		return v.(*expvar.Float)
+	for varName, rv := range reportedVars {
+		if !strings.HasPrefix(varName, prefix) {
+			continue
+		}
+		switch v := rv.v.(type) {
+		case *expvar.Int:
+			v.Set(0)
+		case *expvar.Float:
+			v.Set(0)
+		case *expvar.String:
+			v.Set("")
+		case *expvar.Map:
+			v.Init()
+		case *timerVar:
+			v.reset()
+		}
 	}
-	return expvar.NewFloat(varName)
 }
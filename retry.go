@@ -0,0 +1,115 @@
+package saw
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// RetrySpec configures a Retry saw.
+//
+// Retry wraps a saw writing to flaky backends, retries its Emit() on error.
+type RetrySpec struct {
+	// Retries and final failures are counted under Name.
+	Name string
+	// Max # of attempts including the first one, <= 1 means no retry.
+	MaxAttempts int
+	// Optional, time to wait before n-th retry, starting from 1.
+	Backoff func(attempt int) time.Duration
+	// Whether to retry Result() as well, inner saw must allow Result() being
+	// called again after it fails. Result() stops retrying when ctx is done.
+	RetryResult bool
+}
+
+type retrySaw struct {
+	spec       RetrySpec
+	inner      Saw
+	retryVar   VarInt
+	failureVar VarInt
+}
+
+// Calls f until it succeeds or attempts exhausted, returns the last error.
+func (rs *retrySaw) do(ctx context.Context, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if attempt >= rs.spec.MaxAttempts || ctx.Err() != nil {
+			rs.failureVar.Add(1)
+			return err
+		}
+		rs.retryVar.Add(1)
+		if rs.spec.Backoff != nil {
+			time.Sleep(rs.spec.Backoff(attempt))
+		}
+	}
+}
+
+func (rs *retrySaw) Emit(datum Datum) error {
+	return rs.do(context.Background(), func() error {
+		return rs.inner.Emit(datum)
+	})
+}
+
+func (rs *retrySaw) Result(ctx context.Context) (interface{}, error) {
+	if !rs.spec.RetryResult {
+		return rs.inner.Result(ctx)
+	}
+	var result interface{}
+	err := rs.do(ctx, func() error {
+		var err error
+		result, err = rs.inner.Result(ctx)
+		return err
+	})
+	return result, err
+}
+
+// NewRetry creates a Retry saw wrapping inner.
+func NewRetry(spec RetrySpec, inner Saw) Saw {
+	return &retrySaw{
+		spec:       spec,
+		inner:      inner,
+		retryVar:   ReportInt(spec.Name, "retries"),
+		failureVar: ReportInt(spec.Name, "failures"),
+	}
+}
+
+type resultTimeoutSaw struct {
+	inner   Saw
+	timeout time.Duration
+}
+
+func (rts *resultTimeoutSaw) Emit(datum Datum) error {
+	return rts.inner.Emit(datum)
+}
+
+type resultAndErr struct {
+	result interface{}
+	err    error
+}
+
+func (rts *resultTimeoutSaw) Result(ctx context.Context) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, rts.timeout)
+	defer cancel()
+
+	done := make(chan resultAndErr, 1)
+	go func() {
+		result, err := rts.inner.Result(ctx)
+		done <- resultAndErr{result: result, err: err}
+	}()
+	select {
+	case ret := <-done:
+		return ret.result, ret.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// NewResultTimeout wraps inner so that its Result() returns ctx.Err(),
+// context.DeadlineExceeded normally, when inner Result() doesn't finish in
+// timeout. Inner Result() gets the timeout context and keeps running in
+// background after timeout unless it respects ctx cancellation.
+func NewResultTimeout(inner Saw, timeout time.Duration) Saw {
+	return &resultTimeoutSaw{inner: inner, timeout: timeout}
+}
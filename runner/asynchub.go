@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"sync"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/table"
+	"golang.org/x/net/context"
+)
+
+// AsyncHub wraps a saw.Hub, Publish() schedules datum onto a per-topic Par
+// then returns, subscribers receive it from queues later, so that slow
+// subscribers do not block producers.
+//
+// Datums in different queues are emitted in no particular order, to keep
+// order of datums under same key, set KeyHashFunc so that a key always goes to
+// the same queue.
+type AsyncHub struct {
+	// Selects queue of datum by its key, round-robin when nil. Negative hashes
+	// are taken with sign bit cleared. Must be set before first Publish().
+	KeyHashFunc table.KeyHashFunc
+
+	hub        *saw.Hub
+	varPrefix  string
+	numShards  int
+	bufferSize int
+	group      QueueGroup
+	mu         sync.Mutex
+	pars       map[saw.TopicID]*Par
+}
+
+// Largest int, masks key hash to non-negative.
+const maxKeyHash = int(^uint(0) >> 1)
+
+// Emits to topic of underlying hub, as dst of topic's Par.
+type topicPublisher struct {
+	hub *saw.Hub
+	id  saw.TopicID
+}
+
+func (tp *topicPublisher) Emit(datum saw.Datum) error {
+	if err := tp.hub.PublishChecked(tp.id, datum); err != saw.ErrNoSubscriber {
+		return err
+	}
+	return nil
+}
+
+func (tp *topicPublisher) Result(ctx context.Context) (interface{}, error) {
+	return nil, nil
+}
+
+// NewAsyncHub creates an AsyncHub, each topic published gets a Par of
+// numShards queues, with bufferSize buffer each.
+func NewAsyncHub(varPrefix string, numShards, bufferSize int) *AsyncHub {
+	return &AsyncHub{
+		hub:        saw.NewHub(varPrefix),
+		varPrefix:  varPrefix,
+		numShards:  numShards,
+		bufferSize: bufferSize,
+		pars:       make(map[saw.TopicID]*Par),
+	}
+}
+
+// Hub returns the underlying sync hub.
+func (ah *AsyncHub) Hub() *saw.Hub {
+	return ah.hub
+}
+
+// Register saw that subscribes to a list of Topic, see saw.Hub.Register().
+func (ah *AsyncHub) Register(subscriber saw.Saw, subscribes ...saw.TopicID) {
+	ah.hub.Register(subscriber, subscribes...)
+}
+
+func (ah *AsyncHub) par(id saw.TopicID) *Par {
+	ah.mu.Lock()
+	defer ah.mu.Unlock()
+
+	par, ok := ah.pars[id]
+	if !ok {
+		par = ah.group.NewNamedPar(ah.varPrefix+"."+string(id),
+			&topicPublisher{hub: ah.hub, id: id}, ah.numShards, ah.bufferSize)
+		ah.pars[id] = par
+	}
+	return par
+}
+
+// Publish schedules datum to be published to topic, returns after it's
+// queued. Subscriber errors and dead letters are counted by underlying hub.
+func (ah *AsyncHub) Publish(id saw.TopicID, datum saw.Datum) {
+	hash := SchedRoundRobin
+	if ah.KeyHashFunc != nil {
+		// Sign bit cleared so that negative hashes don't mean round-robin.
+		hash = ah.KeyHashFunc(datum.Key) & maxKeyHash
+	}
+	ah.par(id).Sched(datum, hash)
+}
+
+// Join waits until all datums published are emitted to subscribers, including
+// the ones subscribers publish to ah in turn, then closes queues. Must not be
+// called concurrently with Publish() other than from subscribers, AsyncHub can
+// be published to again after Join() returns. Returns one of subscriber errors
+// since last Join(), if any.
+func (ah *AsyncHub) Join() error {
+	// No lock is held while waiting, subscribers may Publish() to topics
+	// without Par yet, which locks ah.mu and group.
+	ah.group.waitGroup.Wait()
+	ah.mu.Lock()
+	ah.pars = make(map[saw.TopicID]*Par)
+	ah.mu.Unlock()
+	return ah.group.Join()
+}
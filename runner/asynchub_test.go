@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+// Republishes datums to another topic of the hub once gate is closed.
+type republisher struct {
+	hub  *AsyncHub
+	to   saw.TopicID
+	gate chan struct{}
+}
+
+func (rp *republisher) Emit(datum saw.Datum) error {
+	<-rp.gate
+	rp.hub.Publish(rp.to, datum)
+	return nil
+}
+
+func (rp *republisher) Result(ctx context.Context) (interface{}, error) {
+	return nil, nil
+}
+
+type emitCounter struct {
+	saw.SawNoResult
+	n int64
+}
+
+func (ec *emitCounter) Emit(datum saw.Datum) error {
+	atomic.AddInt64(&ec.n, 1)
+	return nil
+}
+
+// Join doesn't deadlock with subscribers publishing to the hub while it
+// waits, and waits for datums they publish.
+func TestAsyncHubJoinChainedTopics(t *testing.T) {
+	hub := NewAsyncHub("asyncHubTest", 4, 2)
+	gate := make(chan struct{})
+	counter := &emitCounter{}
+	hub.Register(&republisher{hub: hub, to: "second", gate: gate}, "first")
+	hub.Register(counter, "second")
+	for i := 0; i < 8; i++ {
+		hub.Publish("first", saw.Datum{})
+	}
+	joined := make(chan error)
+	go func() { joined <- hub.Join() }()
+	time.Sleep(10 * time.Millisecond)
+	close(gate)
+	select {
+	case err := <-joined:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Join() blocked by subscribers publishing to hub")
+	}
+	if n := atomic.LoadInt64(&counter.n); n != 8 {
+		t.Errorf("second topic received %d datums, want 8", n)
+	}
+}
+
+// Records values of each key in order emitted.
+type keyOrderRecorder struct {
+	saw.SawNoResult
+	mu     sync.Mutex
+	values map[saw.DatumKey][]int
+}
+
+func (kr *keyOrderRecorder) Emit(datum saw.Datum) error {
+	kr.mu.Lock()
+	kr.values[datum.Key] = append(kr.values[datum.Key], datum.Value.(int))
+	kr.mu.Unlock()
+	return nil
+}
+
+// Keys of negative hash stay in one queue, keeping their order.
+func TestAsyncHubNegativeKeyHash(t *testing.T) {
+	hub := NewAsyncHub("asyncHubNegativeHash", 4, 2)
+	hub.KeyHashFunc = func(key saw.DatumKey) int { return -len(key) }
+	recorder := &keyOrderRecorder{values: make(map[saw.DatumKey][]int)}
+	hub.Register(recorder, "topic")
+	keys := []saw.DatumKey{"a", "bb", "ccc"}
+	for i := 0; i < 300; i++ {
+		hub.Publish("topic", saw.Datum{Key: keys[i%len(keys)], Value: i})
+	}
+	if err := hub.Join(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		values := recorder.values[key]
+		if len(values) != 100 {
+			t.Fatalf("key %s emitted %d times, want 100", key, len(values))
+		}
+		for i := 1; i < len(values); i++ {
+			if values[i] < values[i-1] {
+				t.Errorf("key %s emitted %d after %d", key, values[i], values[i-1])
+				break
+			}
+		}
+	}
+}
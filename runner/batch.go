@@ -5,11 +5,13 @@ import (
 	"log"
 	"math"
 	"sync"
+	"time"
 
 	"github.com/kuangyh/saw"
 	"github.com/kuangyh/saw/storage"
 	"github.com/kuangyh/saw/table"
 	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
 )
 
 // Speicify one data source of batch computation.
@@ -24,28 +26,85 @@ type BatchSpec struct {
 	// if subscriber doesn't handle concurrent Emit().
 	// NumShards can be equal, smaller or larger than Input.NumShards, implementation
 	// make best effort to keep efficiency.
-	NumShards       int
+	NumShards int
+	// Buffer size of each queue, tune it and NumShards by vars
+	// runner.<Topic>.queueDepth and runner.<Topic>.queueFull.
 	QueueBufferSize int
 	// In re-saw, handler are often a table, provide KeyHashFunc allows pre-hash,
 	// eliminates unneeded contention.
 	KeyHashFunc table.KeyHashFunc
+	// Optional, datums failed in decoding are dropped and counted, OnDecodeError
+	// is called with the raw value, log or route to a dead-letter sink etc.
+	OnDecodeError func(raw []byte, err error)
+	// When true, records input format fails to parse (see
+	// storage.MalformedRecordError) are logged, counted by var
+	// runner.<Topic>.malformedRecords and skipped, instead of failing the shard.
+	// IO errors still fail the shard.
+	SkipErrors bool
+	// Retries failed input shard, see RetryPolicy.
+	Retry RetryPolicy
+	// Max datums per second read from Input, shared by all its shards, defaults
+	// to 0 (unlimited). Limit applies before datums enter queues, so queues drain
+	// at most at this rate, QueueBufferSize and NumShards don't change it.
+	RateLimit int
+	// Optional, called every ProgressInterval (defaults to 10s) and when input
+	// ends, with approximate # bytes read and total input size in bytes, or 0
+	// total when size of input media can't be known.
+	OnProgress       func(read, total int64)
+	ProgressInterval time.Duration
+
+	// Only for RunStream(), whether input ends at io.EOF. When false, reading
+	// input shard is retried after PollInterval (defaults to 1s) on io.EOF with
+	// the same reader, e.g. to follow a growing file.
+	Bounded      bool
+	PollInterval time.Duration
+
+	// Set by RunStream()
+	streaming bool
+}
+
+func (spec *BatchSpec) unbounded() bool {
+	return spec.streaming && !spec.Bounded
+}
+
+// RetryPolicy specifies how to retry reading an input shard when fails.
+//
+// Shard is re-read from the beginning, datums already published are skipped, so
+// it's only correct when input shard content doesn't change between attempts.
+type RetryPolicy struct {
+	// Max # of attempts including the first one, <= 1 means no retry.
+	MaxAttempts int
+	// Optional, time to wait before n-th retry, starting from 1.
+	Backoff func(attempt int) time.Duration
 }
 
 type hubBridge struct {
 	saw.SawNoResult
-	topic        saw.TopicID
-	valueDecoder saw.ValueDecoder
+	// Context of the batch run, passed to subscribers.
+	ctx           context.Context
+	topic         saw.TopicID
+	valueDecoder  saw.ValueDecoder
+	onDecodeError func(raw []byte, err error)
+	decodeErrVar  saw.VarInt
 }
 
 func (hb *hubBridge) Emit(datum saw.Datum) error {
 	if hb.valueDecoder != nil {
-		decodedValue, err := hb.valueDecoder.DecodeValue(datum.Value.([]byte))
+		raw := datum.Value.([]byte)
+		decodedValue, err := hb.valueDecoder.DecodeValue(raw)
 		if err != nil {
-			return err
+			hb.decodeErrVar.Add(1)
+			if hb.onDecodeError != nil {
+				hb.onDecodeError(raw, err)
+			}
+			return nil
 		}
 		datum.Value = decodedValue
 	}
-	saw.GlobalHub.Publish(hb.topic, datum)
+	// Topic without subscriber is only counted as dead letter.
+	if err := saw.GlobalHub.PublishContext(hb.ctx, hb.topic, datum); err != saw.ErrNoSubscriber {
+		return err
+	}
 	return nil
 }
 
@@ -54,48 +113,181 @@ type shardRunner struct {
 	index    int
 	hashFunc table.KeyHashFunc
 	par      *Par
+	retry    RetryPolicy
+	retryVar saw.VarInt
+	// Non-zero for unbounded input, reads again after this on io.EOF.
+	pollInterval time.Duration
+	// Nullable
+	limiter  *rate.Limiter
+	progress *inputProgress
+	// # datums of the shard already scheduled, skipped when re-reading.
+	numRead int64
+	// Nullable, skips malformed records when set.
+	malformedVar saw.VarInt
 }
 
-func (runner *shardRunner) run() {
-	reader, err := runner.rc.DatumReader(context.Background(), runner.index)
+func (runner *shardRunner) run(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		numRead := runner.numRead
+		err := runner.read(ctx)
+		// Unbounded input is read for ever, only failures without progress count.
+		if runner.pollInterval > 0 && runner.numRead > numRead {
+			attempt = 1
+		}
+		if err == nil || ctx.Err() != nil || attempt >= runner.retry.MaxAttempts {
+			return err
+		}
+		runner.retryVar.Add(1)
+		log.Printf(
+			"Retry input %v, shard=%d, attempt=%d, skip=%d",
+			runner.rc, runner.index, attempt, runner.numRead)
+		if runner.retry.Backoff != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(runner.retry.Backoff(attempt)):
+			}
+		}
+	}
+}
+
+func (runner *shardRunner) read(ctx context.Context) error {
+	reader, err := runner.rc.DatumReader(ctx, runner.index)
 	if err != nil {
 		log.Printf(
 			"Unable to open DatumReader for %v, shard=%d, err=%v",
 			runner.rc, runner.index, err)
-		return
+		return err
 	}
 	defer reader.Close()
 
 	var datum saw.Datum
+	// Datums scheduled by previous attempts.
+	toSkip := runner.numRead
+	var skipped int64
 	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
 		datum, err = reader.ReadDatum()
+		if err != nil && runner.malformedVar != nil && storage.IsMalformedRecord(err) {
+			runner.malformedVar.Add(1)
+			log.Printf("Skip record of %v, shard=%d, err=%v", runner.rc, runner.index, err)
+			continue
+		}
+		if err == io.EOF && runner.pollInterval > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(runner.pollInterval):
+			}
+			continue
+		}
 		if err != nil {
 			break
 		}
+		if skipped < toSkip {
+			skipped++
+			continue
+		}
+		if runner.limiter != nil {
+			if err := runner.limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
 		hash := -1
 		if runner.hashFunc != nil {
 			hash = runner.hashFunc(datum.Key)
 		}
 		runner.par.Sched(datum, hash)
+		runner.numRead++
+		runner.progress.addDatum()
 	}
 	if err != io.EOF {
 		log.Printf("DatumReader error for %v, shard=%d, err=%v", runner.rc, runner.index, err)
+		return err
+	}
+	return nil
+}
+
+func newShardRunner(
+	spec BatchSpec, index int, par *Par, limiter *rate.Limiter, progress *inputProgress) *shardRunner {
+	runner := &shardRunner{
+		rc:       spec.Input,
+		index:    index,
+		hashFunc: spec.KeyHashFunc,
+		par:      par,
+		retry:    spec.Retry,
+		retryVar: saw.ReportInt("runner."+string(spec.Topic), "retries"),
+		limiter:  limiter,
+		progress: progress,
+	}
+	if spec.SkipErrors {
+		runner.malformedVar = saw.ReportInt("runner."+string(spec.Topic), "malformedRecords")
+	}
+	if spec.unbounded() {
+		runner.pollInterval = spec.PollInterval
+		if runner.pollInterval <= 0 {
+			runner.pollInterval = time.Second
+		}
 	}
+	return runner
 }
 
-func runInSeq(spec BatchSpec, startInputShard int, numInputShards int, par *Par) {
+// Runs input shards one by one, a failing shard doesn't stop the others, returns
+// one of the errors. Unbounded inputs never end, they run concurrently instead.
+func runInSeq(
+	ctx context.Context, spec BatchSpec, startInputShard int, numInputShards int,
+	par *Par, limiter *rate.Limiter, progress *inputProgress) error {
+	if spec.unbounded() {
+		var wg sync.WaitGroup
+		var collectedErr errCollector
+		for i := startInputShard; i < startInputShard+numInputShards; i++ {
+			wg.Add(1)
+			go func(runner *shardRunner) {
+				if err := runner.run(ctx); err != nil {
+					collectedErr.add(err)
+				}
+				wg.Done()
+			}(newShardRunner(spec, i, par, limiter, progress))
+		}
+		wg.Wait()
+		return collectedErr.get()
+	}
+	var lastErr error
 	for i := startInputShard; i < startInputShard+numInputShards; i++ {
-		runner := shardRunner{
-			rc:       spec.Input,
-			index:    i,
-			hashFunc: spec.KeyHashFunc,
-			par:      par,
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := newShardRunner(spec, i, par, limiter, progress).run(ctx); err != nil {
+			lastErr = err
 		}
-		runner.run()
 	}
+	return lastErr
 }
 
-func runSingleBatch(spec BatchSpec, queueGroup *QueueGroup) {
+// errCollector keeps one of the errors reported concurrently, errors are of
+// different types, so they can't go through atomic.Value.
+type errCollector struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (ec *errCollector) add(err error) {
+	ec.mu.Lock()
+	ec.err = err
+	ec.mu.Unlock()
+}
+
+func (ec *errCollector) get() error {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	return ec.err
+}
+
+func runSingleBatch(ctx context.Context, spec BatchSpec, queueGroup *QueueGroup) error {
 	var numInputShards int
 	if spec.Input.Sharded() {
 		numInputShards = spec.Input.NumShards
@@ -103,10 +295,23 @@ func runSingleBatch(spec BatchSpec, queueGroup *QueueGroup) {
 		numInputShards = 1
 	}
 	var wg sync.WaitGroup
+	var collectedErr errCollector
+	varNs := "runner." + string(spec.Topic)
 	hubBridge := &hubBridge{
-		topic:        spec.Topic,
-		valueDecoder: spec.InputValueDecoder,
+		topic:         spec.Topic,
+		valueDecoder:  spec.InputValueDecoder,
+		onDecodeError: spec.OnDecodeError,
+		decodeErrVar:  saw.ReportInt(varNs, "decodeErrors"),
+	}
+	var limiter *rate.Limiter
+	if spec.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(spec.RateLimit), 1)
 	}
+	progress := newInputProgress(ctx, spec, numInputShards)
+	ctx = progress.context(ctx)
+	hubBridge.ctx = ctx
+	progress.start()
+	defer progress.finish()
 	if spec.NumShards < numInputShards {
 		// 1 runner vs. multiple input
 		var remain float64 = 0.0
@@ -121,8 +326,10 @@ func runSingleBatch(spec BatchSpec, queueGroup *QueueGroup) {
 				log.Printf(
 					"Start runner input=%v, topic=%v, shard=%d:%d, queuePerShard=1",
 					spec.Input, spec.Topic, startInputShard, startInputShard+numInputShards-1)
-				par := queueGroup.NewPar(hubBridge, 1, spec.QueueBufferSize)
-				runInSeq(spec, startInputShard, numInputShards, par)
+				par := queueGroup.NewNamedPar(varNs, hubBridge, 1, spec.QueueBufferSize)
+				if err := runInSeq(ctx, spec, startInputShard, numInputShards, par, limiter, progress); err != nil {
+					collectedErr.add(err)
+				}
 				wg.Done()
 			}(currInputShard, numInputs)
 			currInputShard += numInputs
@@ -140,13 +347,16 @@ func runSingleBatch(spec BatchSpec, queueGroup *QueueGroup) {
 				log.Printf(
 					"Start runner input=%v, topic=%v, shard=%d:%d, queuePerShard=%d",
 					spec.Input, spec.Topic, shardIdx, shardIdx, numQueues)
-				par := queueGroup.NewPar(hubBridge, numQueues, spec.QueueBufferSize)
-				runInSeq(spec, shardIdx, 1, par)
+				par := queueGroup.NewNamedPar(varNs, hubBridge, numQueues, spec.QueueBufferSize)
+				if err := runInSeq(ctx, spec, shardIdx, 1, par, limiter, progress); err != nil {
+					collectedErr.add(err)
+				}
 				wg.Done()
 			}(i, numQueues)
 		}
 	}
 	wg.Wait()
+	return collectedErr.get()
 }
 
 // Run batch job, ingest all source data in parallel, returns after all data
@@ -155,17 +365,38 @@ func runSingleBatch(spec BatchSpec, queueGroup *QueueGroup) {
 // It doesn't guaranttee Saw computation finishes --- in batch program, you must
 // call Result() for top level saws to make sure it fnishes computation and stores
 // data.
-func RunBatch(source ...BatchSpec) {
+//
+// Failing to open or read an input shard, or subscribers' Emit() failing,
+// doesn't stop others, RunBatch still ingests all it can, then returns one of
+// the errors.
+func RunBatch(source ...BatchSpec) error {
+	return RunBatchContext(context.Background(), source...)
+}
+
+// RunBatchContext is RunBatch with a context, ctx is passed to input DatumReaders,
+// when ctx is done, all inputs stop reading, RunBatchContext returns after
+// datums already scheduled are processed, with ctx.Err().
+func RunBatchContext(ctx context.Context, source ...BatchSpec) error {
 	var queueGroup QueueGroup
 	var wg sync.WaitGroup
+	var collectedErr errCollector
 
 	for _, spec := range source {
 		wg.Add(1)
 		go func(spec BatchSpec) {
-			runSingleBatch(spec, &queueGroup)
+			if err := runSingleBatch(ctx, spec, &queueGroup); err != nil {
+				collectedErr.add(err)
+			}
 			wg.Done()
 		}(spec)
 	}
 	wg.Wait()
-	queueGroup.Join()
+	if err := queueGroup.Join(); err != nil {
+		log.Printf("Subscriber error: %v", err)
+		collectedErr.add(err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return collectedErr.get()
 }
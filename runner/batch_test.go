@@ -0,0 +1,27 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/storage"
+)
+
+// Inputs failing with errors of different types must not panic.
+func TestRunBatchMixedErrors(t *testing.T) {
+	err := RunBatch(
+		BatchSpec{
+			Input:     storage.ResourceSpec{Format: "textio", Media: "local", Path: "/nonexistent/input"},
+			Topic:     saw.TopicID("batchTestMissingFile"),
+			NumShards: 1,
+		},
+		BatchSpec{
+			Input:     storage.ResourceSpec{Format: "unknownFormat", Media: "local", Path: "/input"},
+			Topic:     saw.TopicID("batchTestUnknownFormat"),
+			NumShards: 1,
+		},
+	)
+	if err == nil {
+		t.Fatal("RunBatch() returns nil, want error")
+	}
+}
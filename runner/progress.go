@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+// Tracks and reports reading progress of a BatchSpec input, to expvars
+// runner.{input path}.datumsRead, bytesRead and percent (when input size is
+// known), and BatchSpec.OnProgress.
+type inputProgress struct {
+	spec BatchSpec
+	// Total input bytes, 0 when unknown.
+	total      int64
+	bytesRead  int64
+	datumsRead int64
+
+	datumsVar  saw.VarInt
+	bytesVar   saw.VarInt
+	percentVar saw.VarFloat
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func newInputProgress(ctx context.Context, spec BatchSpec, numInputShards int) *inputProgress {
+	ns := "runner." + spec.Input.Path
+	progress := &inputProgress{
+		spec:       spec,
+		datumsVar:  saw.ReportInt(ns, "datumsRead"),
+		bytesVar:   saw.ReportInt(ns, "bytesRead"),
+		percentVar: saw.ReportFloat(ns, "percent"),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for i := 0; i < numInputShards; i++ {
+		size, err := spec.Input.Size(ctx, i)
+		if err != nil {
+			progress.total = 0
+			break
+		}
+		progress.total += size
+	}
+	return progress
+}
+
+// Returns context for creating input readers, so that bytes read get counted.
+func (progress *inputProgress) context(ctx context.Context) context.Context {
+	return storage.WithReadCounter(ctx, &progress.bytesRead)
+}
+
+func (progress *inputProgress) addDatum() {
+	atomic.AddInt64(&progress.datumsRead, 1)
+}
+
+func (progress *inputProgress) report() {
+	bytesRead := atomic.LoadInt64(&progress.bytesRead)
+	progress.datumsVar.Set(atomic.LoadInt64(&progress.datumsRead))
+	progress.bytesVar.Set(bytesRead)
+	if progress.total > 0 {
+		progress.percentVar.Set(float64(bytesRead) * 100.0 / float64(progress.total))
+	}
+	if progress.spec.OnProgress != nil {
+		progress.spec.OnProgress(bytesRead, progress.total)
+	}
+}
+
+// Reports periodically until finish() called.
+func (progress *inputProgress) start() {
+	interval := progress.spec.ProgressInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer close(progress.done)
+		for {
+			select {
+			case <-ticker.C:
+				progress.report()
+			case <-progress.stop:
+				progress.report()
+				return
+			}
+		}
+	}()
+}
+
+func (progress *inputProgress) finish() {
+	close(progress.stop)
+	<-progress.done
+}
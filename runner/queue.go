@@ -1,6 +1,8 @@
 package runner
 
 import (
+	"fmt"
+	"log"
 	"sync"
 	"sync/atomic"
 
@@ -12,32 +14,105 @@ const SchedRoundRobin = -1
 
 // Queue emits Datum to internal Saw in sequence.
 //
-// Queue needed to be created from QueueGroup
+// Queue needed to be created from QueueGroup. Queue, Par and QueueGroup are
+// the only async building blocks, saw.Hub is sync and pipelines wire saws
+// through saw.GlobalHub.
 type Queue struct {
-	dst       saw.Saw
-	waitGroup *sync.WaitGroup
-	chn       chan saw.Datum
+	dst   saw.Saw
+	group *QueueGroup
+	chn   chan saw.Datum
+	// nil when not reported.
+	vars *queueVars
+}
+
+// Vars shared by queues of a Par, see QueueGroup.NewNamedPar().
+type queueVars struct {
+	// # datums scheduled and not yet emitted.
+	depth saw.VarInt
+	// # Sched() blocked for queue full.
+	full saw.VarInt
+	// # TrySched() dropped for queue full.
+	dropped saw.VarInt
+}
+
+func newQueueVars(ns string) *queueVars {
+	return &queueVars{
+		depth:   saw.ReportInt(ns, "queueDepth"),
+		full:    saw.ReportInt(ns, "queueFull"),
+		dropped: saw.ReportInt(ns, "queueDropped"),
+	}
 }
 
 func (q *Queue) run() {
 	for datum := range q.chn {
-		_ = q.dst.Emit(datum)
-		q.waitGroup.Done()
+		if err := q.emit(datum); err != nil {
+			q.group.emitError(datum, err)
+		}
+		if q.vars != nil {
+			q.vars.depth.Add(-1)
+		}
+		q.group.waitGroup.Done()
 	}
 }
 
+// Emits datum to dst, recovers panic in it as error.
+func (q *Queue) emit(datum saw.Datum) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in Emit, key=%s: %v", datum.Key, r)
+			if q.group.PanicHandler != nil {
+				q.group.PanicHandler(datum, r)
+			}
+			err = fmt.Errorf("saw.runner: panic in Emit: %v", r)
+		}
+	}()
+	return q.dst.Emit(datum)
+}
+
 func (q *Queue) close() {
 	close(q.chn)
 }
 
-// Schedule datum processing in queue.
+// Schedule datum processing in queue, blocks when queue is full.
 func (q *Queue) Sched(datum saw.Datum) {
-	q.waitGroup.Add(1)
-	q.chn <- datum
+	q.group.waitGroup.Add(1)
+	if q.vars == nil {
+		q.chn <- datum
+		return
+	}
+	q.vars.depth.Add(1)
+	select {
+	case q.chn <- datum:
+	default:
+		q.vars.full.Add(1)
+		q.chn <- datum
+	}
+}
+
+// TrySched schedules datum processing in queue unless queue is full, returns
+// false when datum is dropped, for pipelines preferring shedding load to
+// stalling producers.
+func (q *Queue) TrySched(datum saw.Datum) bool {
+	q.group.waitGroup.Add(1)
+	select {
+	case q.chn <- datum:
+		if q.vars != nil {
+			q.vars.depth.Add(1)
+		}
+		return true
+	default:
+		q.group.waitGroup.Done()
+		if q.vars != nil {
+			q.vars.dropped.Add(1)
+		}
+		return false
+	}
 }
 
 // Par manages a set of queues, when Sched, it puts task into one of them using
-// hash or round-robin
+// hash or round-robin. Round-robin of each Par in a QueueGroup starts from a
+// different queue, the n-th Par created from queue n, so that short streams
+// over many Pars don't pile onto the same queue index.
 type Par struct {
 	round  uint32
 	queues []*Queue
@@ -48,30 +123,79 @@ type Par struct {
 // selects specific queue by hash. hash is just an optimization to minimize
 // contention, caller should not relie on queue selecting behavior.
 func (par *Par) Sched(datum saw.Datum, hash int) {
+	par.queue(hash).Sched(datum)
+}
+
+// TrySched is Sched() without blocking, datum is dropped and false returns
+// when the selected queue is full, see Queue.TrySched().
+func (par *Par) TrySched(datum saw.Datum, hash int) bool {
+	return par.queue(hash).TrySched(datum)
+}
+
+func (par *Par) queue(hash int) *Queue {
 	var shard int
 	if hash >= 0 {
 		shard = hash % len(par.queues)
 	} else {
-		shard = int(atomic.AddUint32(&par.round, 1)) % len(par.queues)
+		shard = int((atomic.AddUint32(&par.round, 1) - 1) % uint32(len(par.queues)))
 	}
-	par.queues[shard].Sched(datum)
+	return par.queues[shard]
 }
 
 // QueueGroup manages a set of queues running colloaborated tasks.
+//
+// Emit() errors of queues' saws are collected, Join() returns one of them.
 type QueueGroup struct {
+	// Optional, called with datum and error when Emit() of a queue's saw fails,
+	// can be called concurrently from multiple queues.
+	OnError func(datum saw.Datum, err error)
+	// Optional, called with datum and recovered value when Emit() of a queue's
+	// saw panics. Panics are recovered and counted as errors, so that a bad
+	// datum doesn't kill the queue.
+	PanicHandler func(datum saw.Datum, recovered interface{})
+
 	queues    []*Queue
+	numPars   uint32
 	waitGroup sync.WaitGroup
 	mu        sync.Mutex
+	// Since last Join(), guarded by errMu
+	errMu     sync.Mutex
+	numErrors int64
+	firstErr  error
+}
+
+func (group *QueueGroup) emitError(datum saw.Datum, err error) {
+	group.errMu.Lock()
+	if group.numErrors == 0 {
+		group.firstErr = err
+	}
+	group.numErrors++
+	group.errMu.Unlock()
+	if group.OnError != nil {
+		group.OnError(datum, err)
+	}
+}
+
+// NumErrors returns # of Emit() failed since last Join().
+func (group *QueueGroup) NumErrors() int64 {
+	group.errMu.Lock()
+	defer group.errMu.Unlock()
+	return group.numErrors
 }
 
 // New creates a queue managed by this QueueGroup.
 func (group *QueueGroup) New(dst saw.Saw, bufferSize int) *Queue {
+	return group.newQueue(dst, bufferSize, nil)
+}
+
+func (group *QueueGroup) newQueue(dst saw.Saw, bufferSize int, vars *queueVars) *Queue {
 	group.mu.Lock()
 	defer group.mu.Unlock()
 	queue := &Queue{
-		dst:       dst,
-		waitGroup: &group.waitGroup,
-		chn:       make(chan saw.Datum, bufferSize),
+		dst:   dst,
+		group: group,
+		chn:   make(chan saw.Datum, bufferSize),
+		vars:  vars,
 	}
 	go queue.run()
 	group.queues = append(group.queues, queue)
@@ -80,16 +204,30 @@ func (group *QueueGroup) New(dst saw.Saw, bufferSize int) *Queue {
 
 // NewPar creates a par with all its queues managed by this QueueGroup
 func (group *QueueGroup) NewPar(dst saw.Saw, numShards, bufferSize int) *Par {
+	return group.newPar(dst, numShards, bufferSize, nil)
+}
+
+// NewNamedPar is NewPar() reporting backpressure of its queues under ns:
+// queueDepth (datums pending), queueFull (Sched() blocked on a full queue) and
+// queueDropped (TrySched() dropped), for tuning buffer size and # of queues.
+// Pars of the same ns share the vars.
+func (group *QueueGroup) NewNamedPar(ns string, dst saw.Saw, numShards, bufferSize int) *Par {
+	return group.newPar(dst, numShards, bufferSize, newQueueVars(ns))
+}
+
+func (group *QueueGroup) newPar(dst saw.Saw, numShards, bufferSize int, vars *queueVars) *Par {
 	queues := make([]*Queue, numShards)
 	for i := 0; i < numShards; i++ {
-		queues[i] = group.New(dst, bufferSize)
+		queues[i] = group.newQueue(dst, bufferSize, vars)
 	}
-	return &Par{queues: queues}
+	round := atomic.AddUint32(&group.numPars, 1) - 1
+	return &Par{round: round, queues: queues}
 }
 
 // Join waits until all pending tasks in queues done, then close and cleanup
-// all queues it manages
-func (group *QueueGroup) Join() {
+// all queues it manages, returns the first Emit() error since last Join(), if
+// any, and resets error count.
+func (group *QueueGroup) Join() error {
 	group.mu.Lock()
 	defer group.mu.Unlock()
 
@@ -98,4 +236,9 @@ func (group *QueueGroup) Join() {
 		q.close()
 	}
 	group.queues = nil
+	group.errMu.Lock()
+	defer group.errMu.Unlock()
+	err := group.firstErr
+	group.numErrors, group.firstErr = 0, nil
+	return err
 }
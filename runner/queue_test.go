@@ -0,0 +1,65 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kuangyh/saw"
+)
+
+type failingSaw struct {
+	saw.SawNoResult
+}
+
+type valueError struct{ value interface{} }
+
+func (e valueError) Error() string { return fmt.Sprintf("bad value %v", e.value) }
+
+func (fs failingSaw) Emit(datum saw.Datum) error {
+	if datum.Value.(int)%2 == 0 {
+		return errors.New("even value")
+	}
+	return valueError{datum.Value}
+}
+
+// Emit errors are counted across concurrent queues, Join returns one of them
+// and resets, errors after Join may be of a different type.
+func TestQueueGroupJoinMixedErrors(t *testing.T) {
+	group := &QueueGroup{}
+	for round := 0; round < 2; round++ {
+		par := group.NewPar(failingSaw{}, 4, 10)
+		for i := 0; i < 100; i++ {
+			par.Sched(saw.Datum{Value: i*2 + round}, i)
+		}
+		if err := group.Join(); err == nil {
+			t.Fatal("Join() returns nil, want error")
+		}
+		if n := group.NumErrors(); n != 0 {
+			t.Errorf("NumErrors() after Join() = %d, want 0", n)
+		}
+	}
+	group.NewPar(failingSaw{}, 1, 10)
+	if err := group.Join(); err != nil {
+		t.Errorf("Join() without failures returns %v, want nil", err)
+	}
+}
+
+// Round-robin of the n-th Par of a group starts from queue n, then cycles
+// through all queues.
+func TestParRoundRobinSpread(t *testing.T) {
+	group := &QueueGroup{}
+	const numPars, numShards = 6, 4
+	for n := 0; n < numPars; n++ {
+		par := group.NewPar(failingSaw{}, numShards, 10)
+		for i := 0; i < numShards*2; i++ {
+			want := par.queues[(n+i)%numShards]
+			if q := par.queue(-1); q != want {
+				t.Errorf("par %d: round %d doesn't select queue %d", n, i, (n+i)%numShards)
+			}
+		}
+	}
+	if err := group.Join(); err != nil {
+		t.Fatal(err)
+	}
+}
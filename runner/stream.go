@@ -0,0 +1,23 @@
+package runner
+
+import (
+	"golang.org/x/net/context"
+)
+
+// RunStream is like RunBatchContext(), but inputs are streams: unless
+// BatchSpec.Bounded set, reaching io.EOF of an input shard doesn't end it,
+// RunStream keeps polling for new data, until ctx is done.
+//
+// Input shards always run concurrently regardless of BatchSpec.NumShards,
+// NumShards still determines # queues calling subscribers.
+//
+// RunStream returns after all inputs end, or ctx done and datums already
+// scheduled are processed, with ctx.Err().
+func RunStream(ctx context.Context, source ...BatchSpec) error {
+	streams := make([]BatchSpec, len(source))
+	for i, spec := range source {
+		spec.streaming = true
+		streams[i] = spec
+	}
+	return RunBatchContext(ctx, streams...)
+}
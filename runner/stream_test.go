@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+type lineRecorder struct {
+	saw.SawNoResult
+	mu    sync.Mutex
+	lines []string
+}
+
+func (lr *lineRecorder) Emit(datum saw.Datum) error {
+	lr.mu.Lock()
+	lr.lines = append(lr.lines, string(datum.Value.([]byte)))
+	lr.mu.Unlock()
+	return nil
+}
+
+func TestRunStreamFollowsGrowingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString("a\nb\nc")
+
+	recorder := &lineRecorder{}
+	saw.GlobalHub.Register(recorder, "streamTestFollow")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- RunStream(ctx, BatchSpec{
+			Input:        storage.MustParseResourcePath("textio:" + filepath.Join(dir, "input")),
+			Topic:        "streamTestFollow",
+			NumShards:    1,
+			PollInterval: 10 * time.Millisecond,
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	f.WriteString("d\ne\n")
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("RunStream() returns %v, want %v", err, context.Canceled)
+	}
+	want := []string{"a\n", "b\n", "cd\n", "e\n"}
+	if !reflect.DeepEqual(recorder.lines, want) {
+		t.Errorf("got lines %q, want %q", recorder.lines, want)
+	}
+}
@@ -0,0 +1,76 @@
+package saw
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// SamplerSpec configures a Sampler.
+//
+// Sampler is a saw forwards a fraction of datums to its downstream saw, for
+// cheap approximate pipelines.
+type SamplerSpec struct {
+	// Counts of kept and dropped datums are reported under Name.
+	Name string
+	// Probability of a datum being forwarded, in [0, 1].
+	Rate float64
+	// Seeds PRNG, or key hash when ByKey.
+	Seed int64
+	// When true, sampling is decided by hash of datum.Key rather than PRNG, so
+	// that a key is consistently sampled in or out, across runs with same Seed.
+	ByKey bool
+}
+
+type samplerSaw struct {
+	spec       SamplerSpec
+	downstream Saw
+	keptVar    VarInt
+	droppedVar VarInt
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (ss *samplerSaw) sampled(key DatumKey) bool {
+	if ss.spec.ByKey {
+		hash := fnv.New64a()
+		var seed [8]byte
+		binary.LittleEndian.PutUint64(seed[:], uint64(ss.spec.Seed))
+		hash.Write(seed[:])
+		hash.Write([]byte(key))
+		return float64(hash.Sum64())/math.MaxUint64 < ss.spec.Rate
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.rnd.Float64() < ss.spec.Rate
+}
+
+func (ss *samplerSaw) Emit(datum Datum) error {
+	if !ss.sampled(datum.Key) {
+		ss.droppedVar.Add(1)
+		return nil
+	}
+	ss.keptVar.Add(1)
+	return ss.downstream.Emit(datum)
+}
+
+// Result returns result of downstream.
+func (ss *samplerSaw) Result(ctx context.Context) (interface{}, error) {
+	return ss.downstream.Result(ctx)
+}
+
+// NewSampler creates a Sampler forwarding to downstream.
+func NewSampler(spec SamplerSpec, downstream Saw) Saw {
+	return &samplerSaw{
+		spec:       spec,
+		downstream: downstream,
+		keptVar:    ReportInt(spec.Name, "kept"),
+		droppedVar: ReportInt(spec.Name, "dropped"),
+		rnd:        rand.New(rand.NewSource(spec.Seed)),
+	}
+}
@@ -8,10 +8,12 @@ type DatumKey string
 
 // Datum is the data item passed between saws. Datum is a key-value pair where
 // key must be string and value can by anything. The optional SortOrder
-// specifies optimal order when datums with same key get aggregated.
+// specifies optimal order when datums with same key get aggregated, see
+// table.TableSpec.BufferAndSort.
 type Datum struct {
-	Key   DatumKey
-	Value interface{}
+	Key       DatumKey
+	Value     interface{}
+	SortOrder int64
 }
 
 // Saw is the basic computation unit, it's largely a state machine.
@@ -27,6 +29,22 @@ type Saw interface {
 	Result(ctx context.Context) (interface{}, error)
 }
 
+// Saw can optionally provide EmitContext(), so that cancellation, deadline and
+// request-scoped values flow into it. Hub and runner prefer EmitContext() over
+// Emit() when implemented.
+type EmitContextSaw interface {
+	EmitContext(ctx context.Context, v Datum) error
+}
+
+// EmitContext feeds datum into saw, by EmitContext() if saw implements
+// EmitContextSaw, otherwise Emit().
+func EmitContext(ctx context.Context, saw Saw, datum Datum) error {
+	if ecs, ok := saw.(EmitContextSaw); ok {
+		return ecs.EmitContext(ctx, datum)
+	}
+	return saw.Emit(datum)
+}
+
 // Saw can optionally provide Export() interface, it provides a snapshot of its
 // current state, which can be later merged to another saw
 type ExportSaw interface {
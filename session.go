@@ -0,0 +1,124 @@
+package saw
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type SessionFrameFactory func(name string, key DatumKey) (Saw, error)
+
+type SessionWindowSpec struct {
+	Name         string
+	FrameFactory SessionFrameFactory
+	// Session of a key closes after no datum of the key received for Gap.
+	Gap time.Duration
+	// Optional, called with frame's Result() when session closes.
+	OnSessionClose func(key DatumKey, result interface{}, err error)
+}
+
+type session struct {
+	frame *windowFrame
+	timer *time.Timer
+}
+
+// SessionWindow groups datums by key into sessions, each session is a frame saw
+// created by SessionWindowSpec.FrameFactory. Every Emit() extends session of the
+// datum's key, session closes when there's no datum of the key for
+// SessionWindowSpec.Gap, frame's Result() is then called and passed to
+// OnSessionClose. Keys have independent session timers.
+//
+// Like Window, frames can receive concurrent Emit(), but never after its
+// Result() called, such datums are dropped.
+type SessionWindow struct {
+	spec SessionWindowSpec
+
+	mu       sync.Mutex
+	sessions map[DatumKey]*session
+
+	finalizeWg sync.WaitGroup
+
+	droppedCount VarInt
+	openedCount  VarInt
+	closedCount  VarInt
+}
+
+func NewSessionWindow(spec SessionWindowSpec) *SessionWindow {
+	return &SessionWindow{
+		spec:         spec,
+		sessions:     make(map[DatumKey]*session),
+		droppedCount: ReportInt(spec.Name, "droppedCount"),
+		openedCount:  ReportInt(spec.Name, "sessionsOpened"),
+		closedCount:  ReportInt(spec.Name, "sessionsClosed"),
+	}
+}
+
+func (win *SessionWindow) finalize(ctx context.Context, key DatumKey, sess *session) {
+	result, err := sess.frame.result(ctx)
+	if win.spec.OnSessionClose != nil {
+		win.spec.OnSessionClose(key, result, err)
+	}
+	win.closedCount.Add(1)
+	win.finalizeWg.Done()
+}
+
+func (win *SessionWindow) expire(key DatumKey, sess *session) {
+	win.mu.Lock()
+	if win.sessions[key] == sess {
+		delete(win.sessions, key)
+	}
+	win.mu.Unlock()
+	win.finalize(context.Background(), key, sess)
+}
+
+func (win *SessionWindow) prepareSession(key DatumKey) (*session, error) {
+	win.mu.Lock()
+	defer win.mu.Unlock()
+
+	sess, ok := win.sessions[key]
+	// Stop() fails when session is expiring, starts a new one.
+	if ok && sess.timer.Stop() {
+		sess.timer.Reset(win.spec.Gap)
+		return sess, nil
+	}
+	saw, err := win.spec.FrameFactory(win.spec.Name, key)
+	if err != nil {
+		return nil, err
+	}
+	sess = &session{frame: &windowFrame{saw: saw}}
+	win.finalizeWg.Add(1)
+	sess.timer = time.AfterFunc(win.spec.Gap, func() { win.expire(key, sess) })
+	win.sessions[key] = sess
+	win.openedCount.Add(1)
+	return sess, nil
+}
+
+func (win *SessionWindow) Emit(datum Datum) error {
+	sess, err := win.prepareSession(datum.Key)
+	if err != nil {
+		return err
+	}
+	emitted, err := sess.frame.emit(datum)
+	if !emitted {
+		win.droppedCount.Add(1)
+	}
+	return err
+}
+
+// Result closes all open sessions, returns after all sessions finalized,
+// including ones closed by timeout earlier.
+func (win *SessionWindow) Result(ctx context.Context) (interface{}, error) {
+	win.mu.Lock()
+	for key, sess := range win.sessions {
+		// Otherwise it's expiring and will be finalized by timer.
+		if sess.timer.Stop() {
+			go win.finalize(ctx, key, sess)
+		}
+	}
+	win.sessions = make(map[DatumKey]*session)
+	win.mu.Unlock()
+
+	win.finalizeWg.Wait()
+	return nil, nil
+}
@@ -0,0 +1,41 @@
+package saw
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+)
+
+type discardSaw struct {
+	SawNoResult
+}
+
+func (ds discardSaw) Emit(datum Datum) error {
+	return nil
+}
+
+// Discard is a Saw that drops all datums and has nil result, like io.Discard,
+// e.g. as Hub subscriber to test wiring or benchmark runner without side
+// effects.
+var Discard Saw = discardSaw{}
+
+// CountingSink is a Saw that drops datums but counts them, Emit() is
+// concurrent safe.
+type CountingSink struct {
+	count int64
+}
+
+func (cs *CountingSink) Emit(datum Datum) error {
+	atomic.AddInt64(&cs.count, 1)
+	return nil
+}
+
+// Returns # of datums emitted so far.
+func (cs *CountingSink) Count() int64 {
+	return atomic.LoadInt64(&cs.count)
+}
+
+// Returns int64 # of datums emitted.
+func (cs *CountingSink) Result(ctx context.Context) (interface{}, error) {
+	return cs.Count(), nil
+}
@@ -13,8 +13,10 @@ import (
 // Media: gs
 // Read / write from Google Cloud storage, your resource path looks like
 // format:/gs/bucket-name/object-name, sharding supported with recommended naming.
+// Objects with name ending with ".gz" are read and written through gzip.
 // EXPERIMENTAL: bugs bugs.
 type GCSMedia struct {
+	GzipSuffixDecompressor
 }
 
 func (gm GCSMedia) IOReader(
@@ -45,9 +47,19 @@ type waitWriteHalf struct {
 }
 
 func (wh *waitWriteHalf) Close() error {
+	return wh.CloseContext(context.Background())
+}
+
+// Waits for upload to finish until ctx is done, the upload continues in
+// background after that.
+func (wh *waitWriteHalf) CloseContext(ctx context.Context) error {
 	wh.PipeWriter.Close()
-	<-wh.finish
-	return wh.err
+	select {
+	case <-wh.finish:
+		return wh.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (gm GCSMedia) IOWriter(
@@ -71,7 +83,7 @@ func (gm GCSMedia) IOWriter(
 	call := serv.Objects.Insert(pair[0], &gcs.Object{Name: pair[1]})
 	go func() {
 		if _, err := call.Media(pr).Do(); err != nil {
-			log.Printf("gcs write bucket=%s name=%s err %v, %v", pair[0], pair[1], err)
+			log.Printf("gcs write bucket=%s name=%s err %v", pair[0], pair[1], err)
 			handle.err = err
 		}
 		pr.Close()
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Paths ending with this are read and written through gzip by media embedding
+// GzipSuffixDecompressor.
+const gzipSuffix = ".gz"
+
+// GzipSuffixDecompressor implements DecompressingMedia and CompressingMedia for
+// file-like media, it decompresses shards by gzip when Path ends with ".gz",
+// and compresses shards written to such path, so that output of a table can be
+// read back. An empty shard reads as empty input. Embedded by local and gs
+// media.
+type GzipSuffixDecompressor struct{}
+
+func (gd GzipSuffixDecompressor) DecompressReader(
+	ctx context.Context, rc ResourceSpec, shard int, reader io.ReadCloser) (io.ReadCloser, error) {
+	return maybeGzipReader(&rc, reader)
+}
+
+func (gd GzipSuffixDecompressor) CompressWriter(
+	ctx context.Context, rc ResourceSpec, shard int, writer io.WriteCloser) (io.WriteCloser, error) {
+	if !strings.HasSuffix(rc.Path, gzipSuffix) {
+		return writer, nil
+	}
+	return &gzipWriteCloser{Writer: gzip.NewWriter(writer), internal: writer}, nil
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	internal io.ReadCloser
+}
+
+// Closes both gzip reader and the underlying reader.
+func (gr *gzipReadCloser) Close() error {
+	err := gr.Reader.Close()
+	if internalErr := gr.internal.Close(); err == nil {
+		err = internalErr
+	}
+	return err
+}
+
+// Wraps reader in gzip when path of rc is gzip compressed, takes ownership of
+// reader, it's closed when error.
+func maybeGzipReader(rc *ResourceSpec, reader io.ReadCloser) (io.ReadCloser, error) {
+	if !strings.HasSuffix(rc.Path, gzipSuffix) {
+		return reader, nil
+	}
+	gzipReader, err := gzip.NewReader(reader)
+	if err == io.EOF {
+		// Empty shard, reader is at EOF as well.
+		return reader, nil
+	}
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: gzipReader, internal: reader}, nil
+}
+
+type gzipWriteCloser struct {
+	*gzip.Writer
+	internal io.WriteCloser
+}
+
+func (gw *gzipWriteCloser) Close() error {
+	return gw.CloseContext(context.Background())
+}
+
+// Flushes gzip stream and closes the underlying writer, by its CloseContext()
+// when it implements ContextCloser.
+func (gw *gzipWriteCloser) CloseContext(ctx context.Context) error {
+	err := gw.Writer.Close()
+	if internalErr := CloseContext(ctx, gw.internal); err == nil {
+		err = internalErr
+	}
+	return err
+}
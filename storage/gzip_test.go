@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+// textio written to a ".gz" path is gzip compressed and reads back, an empty
+// shard reads as empty input.
+func TestGzipRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rc := MustParseResourcePath("textio:" + filepath.Join(dir, "out.gz") + "@2")
+	lines := []string{"a", "b"}
+	for shard := 0; shard < 2; shard++ {
+		writer, err := rc.DatumWriter(context.Background(), shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shard == 0 {
+			for _, line := range lines {
+				if err := writer.WriteDatum(saw.Datum{Value: []byte(line)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := ioutil.ReadFile(rc.ShardPath(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+		t.Errorf("shard 0 is not gzip: %q", raw)
+	}
+	// Zero-byte shard, e.g. created by a writer that never wrote.
+	os.Truncate(rc.ShardPath(1), 0)
+
+	for shard, want := range [][]string{lines, nil} {
+		reader, err := rc.DatumReader(context.Background(), shard)
+		if err != nil {
+			t.Fatalf("shard %d: %v", shard, err)
+		}
+		var got []string
+		for {
+			datum, err := reader.ReadDatum()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("shard %d: %v", shard, err)
+			}
+			got = append(got, string(datum.Value.([]byte)))
+		}
+		reader.Close()
+		if len(got) != len(want) {
+			t.Errorf("shard %d reads %q, want %q", shard, got, want)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i]+"\n" {
+				t.Errorf("shard %d line %d = %q, want %q", shard, i, got[i], want[i]+"\n")
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kuangyh/saw"
+	"github.com/segmentio/kafka-go"
+	"golang.org/x/net/context"
+)
+
+// Max time a message waits in writer for its batch to fill, kafka-go defaults
+// to 1s.
+const kafkaBatchTimeout = 10 * time.Millisecond
+
+// Format: kafka
+// Reads and writes one datum per Kafka message, datum.Key as message key and
+// datum.Value ([]byte) as message value. Path is /broker1,broker2/topic, Media in
+// ResourceSpec is ignored, eg. "kafka:/localhost:9092/events@8". ResourceSpec
+// must be sharded, shard maps to partition of the same index, NumShards is #
+// partitions to read or write.
+//
+// DatumReader consumes messages of a partition from its first offset and never
+// returns io.EOF, it's meant for RunStream(), ReadDatum() returns when ctx of
+// DatumReader() is done. DatumWriter sends messages asynchronously in batches,
+// WriteDatum() doesn't wait for delivery; a delivery failure is returned by
+// later writes and Close(), which waits for pending messages.
+type KafkaFormat struct {
+}
+
+var ErrKafkaNotSharded = errors.New("kafka resource must be sharded by partitions")
+
+func parseKafkaPath(rc ResourceSpec) (brokers []string, topic string, err error) {
+	if !rc.Sharded() {
+		return nil, "", ErrKafkaNotSharded
+	}
+	parts := strings.SplitN(strings.TrimPrefix(rc.Path, "/"), "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, "", ErrMalformedPath
+	}
+	return strings.Split(parts[0], ","), parts[1], nil
+}
+
+func (kf KafkaFormat) DatumReader(
+	ctx context.Context, rc ResourceSpec, shard int) (DatumReader, error) {
+	brokers, topic, err := parseKafkaPath(rc)
+	if err != nil {
+		return nil, err
+	}
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   brokers,
+		Topic:     topic,
+		Partition: shard,
+	})
+	return &kafkaDatumReader{ctx: ctx, reader: reader}, nil
+}
+
+func (kf KafkaFormat) DatumWriter(
+	ctx context.Context, rc ResourceSpec, shard int) (DatumWriter, error) {
+	brokers, topic, err := parseKafkaPath(rc)
+	if err != nil {
+		return nil, err
+	}
+	dw := &kafkaDatumWriter{ctx: ctx}
+	dw.writer = &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     kafkaPartition(shard),
+		BatchTimeout: kafkaBatchTimeout,
+		Async:        true,
+		Completion:   dw.complete,
+	}
+	return dw, nil
+}
+
+// Balancer always selects a fixed partition.
+type kafkaPartition int
+
+func (kp kafkaPartition) Balance(msg kafka.Message, partitions ...int) int {
+	return int(kp)
+}
+
+type kafkaDatumReader struct {
+	ctx    context.Context
+	reader *kafka.Reader
+}
+
+func (dr *kafkaDatumReader) ReadDatum() (saw.Datum, error) {
+	msg, err := dr.reader.ReadMessage(dr.ctx)
+	if err != nil {
+		return saw.Datum{}, err
+	}
+	return saw.Datum{Key: saw.DatumKey(msg.Key), Value: msg.Value}, nil
+}
+
+func (dr *kafkaDatumReader) Close() error {
+	return dr.reader.Close()
+}
+
+type kafkaDatumWriter struct {
+	ctx    context.Context
+	writer *kafka.Writer
+
+	mu sync.Mutex
+	// First delivery failure reported by writer.
+	err error
+}
+
+// Called by writer when a batch is delivered or fails.
+func (dw *kafkaDatumWriter) complete(messages []kafka.Message, err error) {
+	if err == nil {
+		return
+	}
+	dw.mu.Lock()
+	if dw.err == nil {
+		dw.err = err
+	}
+	dw.mu.Unlock()
+}
+
+func (dw *kafkaDatumWriter) deliveryErr() error {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	return dw.err
+}
+
+func (dw *kafkaDatumWriter) WriteDatum(datum saw.Datum) error {
+	return dw.WriteDatums([]saw.Datum{datum})
+}
+
+// Values are copied, messages are sent after return.
+func (dw *kafkaDatumWriter) WriteDatums(datums []saw.Datum) error {
+	if err := dw.deliveryErr(); err != nil {
+		return err
+	}
+	msgs := make([]kafka.Message, len(datums))
+	for i, datum := range datums {
+		msgs[i] = kafka.Message{
+			Key:   []byte(datum.Key),
+			Value: append([]byte(nil), datum.Value.([]byte)...),
+		}
+	}
+	return dw.writer.WriteMessages(dw.ctx, msgs...)
+}
+
+func (dw *kafkaDatumWriter) Close() error {
+	closeErr := dw.writer.Close()
+	if err := dw.deliveryErr(); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func init() {
+	RegisterStorageFormat("kafka", KafkaFormat{})
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKafkaPath(t *testing.T) {
+	rc := MustParseResourcePath("kafka:/broker1:9092,broker2:9092/events@8")
+	brokers, topic, err := parseKafkaPath(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"broker1:9092", "broker2:9092"}; !reflect.DeepEqual(brokers, want) {
+		t.Errorf("brokers = %v, want %v", brokers, want)
+	}
+	if topic != "events" {
+		t.Errorf("topic = %q, want events", topic)
+	}
+
+	if _, _, err := parseKafkaPath(MustParseResourcePath("kafka:/broker1:9092/events")); err != ErrKafkaNotSharded {
+		t.Errorf("unsharded path returns %v, want ErrKafkaNotSharded", err)
+	}
+	if _, _, err := parseKafkaPath(MustParseResourcePath("kafka:/broker1:9092@8")); err != ErrMalformedPath {
+		t.Errorf("path without topic returns %v, want ErrMalformedPath", err)
+	}
+}
@@ -10,7 +10,9 @@ import (
 // Media: local
 // Read / write local file system.
 // Special path name STDIN, STDOUT, STDERR has their conventional meaning.
+// Files with path ending with ".gz" are read and written through gzip.
 type LocalMedia struct {
+	GzipSuffixDecompressor
 }
 
 func (lm LocalMedia) IOReader(
@@ -21,6 +23,17 @@ func (lm LocalMedia) IOReader(
 	return os.Open(rc.ShardPath(shard))
 }
 
+func (lm LocalMedia) Size(ctx context.Context, rc ResourceSpec, shard int) (int64, error) {
+	if rc.Path == "STDIN" {
+		return 0, ErrStorageFeatureNotSupported
+	}
+	info, err := os.Stat(rc.ShardPath(shard))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 func (lm LocalMedia) IOWriter(
 	ctx context.Context, rc ResourceSpec, shard int) (io.WriteCloser, error) {
 	if rc.Path == "STDOUT" {
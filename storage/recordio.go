@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bufio"
 	"io"
 	"strconv"
 
@@ -23,9 +24,10 @@ func (rf RecordIOFormat) DatumReader(
 	if err != nil {
 		return nil, err
 	}
-	// TODO(yuheng): consider using bufio
+	// Record header and payload are separate reads, buffered to save syscalls
+	// and round trips.
 	return &recordIODatumReader{
-		rr:       recordio.NewReader(f),
+		rr:       recordio.NewReader(bufio.NewReaderSize(f, rc.bufferSize())),
 		internal: f,
 		readKey:  rf.withKey,
 		shardKey: saw.DatumKey(strconv.Itoa(shard)),
@@ -38,9 +40,10 @@ func (rf RecordIOFormat) DatumWriter(
 	if err != nil {
 		return nil, err
 	}
-	// TODO(yuheng): consider using bufio
+	buffered := bufio.NewWriterSize(f, rc.bufferSize())
 	return &recordIODatumWriter{
-		rw:       recordio.NewWriter(f, recordio.DefaultFlags),
+		rw:       recordio.NewWriter(buffered, recordio.DefaultFlags),
+		buffered: buffered,
 		internal: f,
 		writeKey: rf.withKey,
 	}, nil
@@ -76,6 +79,7 @@ func (reader *recordIODatumReader) Close() error {
 
 type recordIODatumWriter struct {
 	rw       *recordio.Writer
+	buffered *bufio.Writer
 	internal io.WriteCloser
 
 	writeKey bool
@@ -104,7 +108,17 @@ func (writer *recordIODatumWriter) WriteDatum(datum saw.Datum) (err error) {
 }
 
 func (writer *recordIODatumWriter) Close() error {
-	return writer.internal.Close()
+	return writer.CloseContext(context.Background())
+}
+
+// Flushes buffered records and closes underlying writer, a flush error doesn't
+// prevent closing.
+func (writer *recordIODatumWriter) CloseContext(ctx context.Context) error {
+	flushErr := writer.buffered.Flush()
+	if err := CloseContext(ctx, writer.internal); err != nil {
+		return err
+	}
+	return flushErr
 }
 
 func init() {
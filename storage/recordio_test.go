@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+func benchmarkRecordIORead(b *testing.B, bufferSize int) {
+	dir, err := ioutil.TempDir("", "recordio")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rc := MustParseResourcePath("recordkv:" + filepath.Join(dir, "data"))
+	rc.BufferSize = bufferSize
+
+	const numDatums = 10000
+	value := make([]byte, 100)
+	writer, err := rc.DatumWriter(context.Background(), 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < numDatums; i++ {
+		if err := writer.WriteDatum(saw.Datum{Key: "key", Value: value}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(numDatums * int64(len("key")+len(value)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader, err := rc.DatumReader(context.Background(), 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			if _, err := reader.ReadDatum(); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+		reader.Close()
+	}
+}
+
+// Read throughput with the default buffer vs. a 64KiB one.
+func BenchmarkRecordIORead(b *testing.B) {
+	b.Run("default", func(b *testing.B) { benchmarkRecordIORead(b, 0) })
+	b.Run("64KiB", func(b *testing.B) { benchmarkRecordIORead(b, 64<<10) })
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+var ErrRedisProtocol = errors.New("redis protocol error")
+
+// # of commands pipelined before reading their replies.
+const redisPipelineSize = 256
+
+// Format: redis
+// Stores datum.Key -> datum.Value ([]byte) as Redis strings, by SET and GET.
+// Path is /host:port/db/prefix, keys are stored as prefix + datum.Key. Media in
+// ResourceSpec is ignored, eg. "redis:/localhost:6379/0/wordcount:@16".
+//
+// Writers of all shards write to the same keyspace, reader of a shard SCANs
+// keys under prefix and reads the ones whose fnv32 hash of datum.Key falls in
+// the shard.
+type RedisFormat struct {
+}
+
+func parseRedisPath(path string) (addr string, db int, prefix string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(parts) < 2 || len(parts[0]) == 0 {
+		return "", 0, "", ErrMalformedPath
+	}
+	if db, err = strconv.Atoi(parts[1]); err != nil {
+		return "", 0, "", ErrMalformedPath
+	}
+	if len(parts) == 3 {
+		prefix = parts[2]
+	}
+	return parts[0], db, prefix, nil
+}
+
+func (rf RedisFormat) DatumReader(
+	ctx context.Context, rc ResourceSpec, shard int) (DatumReader, error) {
+	conn, prefix, err := dialRedis(ctx, rc)
+	if err != nil {
+		return nil, err
+	}
+	return &redisDatumReader{
+		conn:      conn,
+		prefix:    prefix,
+		shard:     shard,
+		numShards: rc.NumShards,
+		cursor:    "0",
+	}, nil
+}
+
+func (rf RedisFormat) DatumWriter(
+	ctx context.Context, rc ResourceSpec, shard int) (DatumWriter, error) {
+	conn, prefix, err := dialRedis(ctx, rc)
+	if err != nil {
+		return nil, err
+	}
+	return &redisDatumWriter{conn: conn, prefix: prefix}, nil
+}
+
+// A minimal RESP client, commands can be pipelined by send() then reply().
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func dialRedis(ctx context.Context, rc ResourceSpec) (*redisConn, string, error) {
+	addr, db, prefix, err := parseRedisPath(rc.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+	rconn := &redisConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+	rconn.send("SELECT", []byte(strconv.Itoa(db)))
+	if _, err := rconn.reply(); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	return rconn, prefix, nil
+}
+
+// Buffers command, errors surface in reply().
+func (rc *redisConn) send(cmd string, args ...[]byte) {
+	fmt.Fprintf(rc.writer, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(cmd), cmd)
+	for _, arg := range args {
+		fmt.Fprintf(rc.writer, "$%d\r\n", len(arg))
+		rc.writer.Write(arg)
+		rc.writer.WriteString("\r\n")
+	}
+}
+
+// Flushes commands sent and reads one reply: string or int64 as []byte, nil for
+// null bulk string, []interface{} for arrays, error replies as error.
+func (rc *redisConn) reply() (interface{}, error) {
+	if err := rc.writer.Flush(); err != nil {
+		return nil, err
+	}
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, ErrRedisProtocol
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+', ':':
+		return []byte(body), nil
+	case '-':
+		return nil, errors.New("redis: " + body)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, ErrRedisProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.reader, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, ErrRedisProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = rc.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, ErrRedisProtocol
+}
+
+func (rc *redisConn) Close() error {
+	return rc.conn.Close()
+}
+
+// Escapes glob special characters for SCAN MATCH.
+func redisGlobEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+type redisDatumReader struct {
+	conn      *redisConn
+	prefix    string
+	shard     int
+	numShards int
+	cursor    string
+	// Datums fetched but not yet read.
+	pending []saw.Datum
+}
+
+func (dr *redisDatumReader) inShard(key saw.DatumKey) bool {
+	if dr.numShards <= 0 {
+		return true
+	}
+	hash := fnv.New32()
+	hash.Write([]byte(key))
+	return int(hash.Sum32()%uint32(dr.numShards)) == dr.shard
+}
+
+// Scans next batch of keys and GETs ones in shard.
+func (dr *redisDatumReader) fetch() error {
+	dr.conn.send("SCAN", []byte(dr.cursor),
+		[]byte("MATCH"), []byte(redisGlobEscape(dr.prefix)+"*"),
+		[]byte("COUNT"), []byte(strconv.Itoa(redisPipelineSize)))
+	ret, err := dr.conn.reply()
+	if err != nil {
+		return err
+	}
+	scan, ok := ret.([]interface{})
+	if !ok || len(scan) != 2 {
+		return ErrRedisProtocol
+	}
+	cursor, ok := scan[0].([]byte)
+	keys, ok2 := scan[1].([]interface{})
+	if !ok || !ok2 {
+		return ErrRedisProtocol
+	}
+	dr.cursor = string(cursor)
+
+	var shardKeys []saw.DatumKey
+	for _, k := range keys {
+		redisKey, ok := k.([]byte)
+		if !ok {
+			return ErrRedisProtocol
+		}
+		key := saw.DatumKey(redisKey[len(dr.prefix):])
+		if dr.inShard(key) {
+			shardKeys = append(shardKeys, key)
+			dr.conn.send("GET", redisKey)
+		}
+	}
+	for _, key := range shardKeys {
+		value, err := dr.conn.reply()
+		if err != nil {
+			return err
+		}
+		// Deleted since SCAN
+		if value == nil {
+			continue
+		}
+		dr.pending = append(dr.pending, saw.Datum{Key: key, Value: value})
+	}
+	return nil
+}
+
+func (dr *redisDatumReader) ReadDatum() (saw.Datum, error) {
+	for len(dr.pending) == 0 {
+		if dr.cursor == "" {
+			return saw.Datum{}, io.EOF
+		}
+		if err := dr.fetch(); err != nil {
+			return saw.Datum{}, err
+		}
+		// SCAN iteration ends when cursor returns to 0.
+		if dr.cursor == "0" {
+			dr.cursor = ""
+		}
+	}
+	datum := dr.pending[0]
+	dr.pending = dr.pending[1:]
+	return datum, nil
+}
+
+func (dr *redisDatumReader) Close() error {
+	return dr.conn.Close()
+}
+
+type redisDatumWriter struct {
+	conn   *redisConn
+	prefix string
+	// # SETs sent and not replied.
+	numPending int
+}
+
+// Reads replies of SETs pending, returns the first error.
+func (dw *redisDatumWriter) flush() error {
+	var firstErr error
+	for ; dw.numPending > 0; dw.numPending-- {
+		if _, err := dw.conn.reply(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (dw *redisDatumWriter) WriteDatum(datum saw.Datum) error {
+	dw.conn.send("SET", []byte(dw.prefix+string(datum.Key)), datum.Value.([]byte))
+	dw.numPending++
+	if dw.numPending >= redisPipelineSize {
+		return dw.flush()
+	}
+	return nil
+}
+
+func (dw *redisDatumWriter) Close() error {
+	err := dw.flush()
+	if closeErr := dw.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func init() {
+	RegisterStorageFormat("redis", RedisFormat{})
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kuangyh/saw"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrSSTableLocalOnly    = errors.New("sstable only supports local media")
+	ErrMalformedSSTableKey = errors.New("malformed sstable key")
+)
+
+// Length of sequence suffix appended to datum.Key in SSTable keys.
+const ssTableSeqLen = 8
+
+// Ends datum.Key in SSTable keys, NUL bytes of datum.Key are escaped as
+// ssTableEscapedNUL, so that keys sort by datum.Key before sequence #.
+var (
+	ssTableKeyEnd     = []byte{0x00, 0x01}
+	ssTableEscapedNUL = []byte{0x00, 0xff}
+)
+
+// Format: sstable
+// Stores datums in a LevelDB per shard at ShardPath(), only on local media, so
+// that output is sorted by key and can be opened and queried as a LevelDB.
+//
+// LevelDB keys are datum.Key, with NUL bytes escaped as "\x00\xff", then
+// "\x00\x01" and 8 bytes big-endian sequence # of the datum in its shard, so
+// that datums of the same key are all kept, in the order written, and keys
+// sort as datum.Key does; values are datum.Value ([]byte). Reader decodes
+// datum.Key, reads datums in key order.
+//
+// DatumWriter() removes LevelDB already at the shard path, e.g. of a previous
+// run, as textio and recordio truncate their files.
+//
+// Options come from ctx of DatumWriter() when set by WithSSTableOptions(),
+// e.g. TableSpec.SSTableOptions of tables, otherwise from Options.
+type SSTableFormat struct {
+	Options SSTableOptions
+}
+
+// SSTableOptions tunes LevelDB written by sstable format, zero fields take
+// values of DefaultSSTableOptions. Raise WriteBuffer and CompactionTableSize
+// for bulk writes of large outputs to reduce compaction.
+type SSTableOptions struct {
+	// Size of each sorted table file.
+	CompactionTableSize int
+	// Total size of level-1 tables, each level above is 10x larger.
+	CompactionTotalSize int
+	// Size of in-memory buffer before it's written as a table.
+	WriteBuffer int
+}
+
+var DefaultSSTableOptions = SSTableOptions{
+	CompactionTableSize: 8 << 20,
+	CompactionTotalSize: 64 << 20,
+	WriteBuffer:         16 << 20,
+}
+
+func (so SSTableOptions) withDefaults() SSTableOptions {
+	if so.CompactionTableSize <= 0 {
+		so.CompactionTableSize = DefaultSSTableOptions.CompactionTableSize
+	}
+	if so.CompactionTotalSize <= 0 {
+		so.CompactionTotalSize = DefaultSSTableOptions.CompactionTotalSize
+	}
+	if so.WriteBuffer <= 0 {
+		so.WriteBuffer = DefaultSSTableOptions.WriteBuffer
+	}
+	return so
+}
+
+type ssTableOptionsKey struct{}
+
+// Returns a context that makes sstable DatumWriter() created with it use
+// options instead of the ones of registered format.
+func WithSSTableOptions(ctx context.Context, options SSTableOptions) context.Context {
+	return context.WithValue(ctx, ssTableOptionsKey{}, options)
+}
+
+func (sf SSTableFormat) open(
+	ctx context.Context, rc ResourceSpec, shard int, readOnly bool) (*leveldb.DB, error) {
+	if rc.Media != localMediaName {
+		return nil, ErrSSTableLocalOnly
+	}
+	options := sf.Options
+	if ctxOptions, ok := ctx.Value(ssTableOptionsKey{}).(SSTableOptions); ok {
+		options = ctxOptions
+	}
+	options = options.withDefaults()
+	if !readOnly {
+		if err := removeLevelDB(rc.ShardPath(shard)); err != nil {
+			return nil, err
+		}
+	}
+	return leveldb.OpenFile(rc.ShardPath(shard), &opt.Options{
+		CompactionTableSize: options.CompactionTableSize,
+		CompactionTotalSize: options.CompactionTotalSize,
+		WriteBuffer:         options.WriteBuffer,
+		ReadOnly:            readOnly,
+		ErrorIfMissing:      readOnly,
+	})
+}
+
+// Removes LevelDB at path if any, directories that are not LevelDB, i.e.
+// without CURRENT file, are kept.
+func removeLevelDB(path string) error {
+	if _, err := os.Stat(filepath.Join(path, "CURRENT")); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+func (sf SSTableFormat) DatumReader(
+	ctx context.Context, rc ResourceSpec, shard int) (DatumReader, error) {
+	db, err := sf.open(ctx, rc, shard, true)
+	if err != nil {
+		return nil, err
+	}
+	return &ssTableDatumReader{db: db, iter: db.NewIterator(nil, nil)}, nil
+}
+
+func (sf SSTableFormat) DatumWriter(
+	ctx context.Context, rc ResourceSpec, shard int) (DatumWriter, error) {
+	db, err := sf.open(ctx, rc, shard, false)
+	if err != nil {
+		return nil, err
+	}
+	return &ssTableDatumWriter{db: db}, nil
+}
+
+// Datums are read in order of LevelDB keys.
+func (sf SSTableFormat) SortedByKey() bool {
+	return true
+}
+
+type ssTableDatumReader struct {
+	db   *leveldb.DB
+	iter iterator.Iterator
+}
+
+func (dr *ssTableDatumReader) ReadDatum() (saw.Datum, error) {
+	if !dr.iter.Next() {
+		if err := dr.iter.Error(); err != nil {
+			return saw.Datum{}, err
+		}
+		return saw.Datum{}, io.EOF
+	}
+	key, ok := decodeSSTableKey(dr.iter.Key())
+	if !ok {
+		return saw.Datum{}, &MalformedRecordError{Err: ErrMalformedSSTableKey}
+	}
+	// Iterator reuses its buffers.
+	value := make([]byte, len(dr.iter.Value()))
+	copy(value, dr.iter.Value())
+	return saw.Datum{Key: key, Value: value}, nil
+}
+
+// Strips sequence # and end of key, unescapes NUL bytes.
+func decodeSSTableKey(encoded []byte) (saw.DatumKey, bool) {
+	end := len(encoded) - ssTableSeqLen - len(ssTableKeyEnd)
+	if end < 0 || !bytes.Equal(encoded[end:end+len(ssTableKeyEnd)], ssTableKeyEnd) {
+		return "", false
+	}
+	encoded = encoded[:end]
+	if bytes.IndexByte(encoded, 0x00) < 0 {
+		return saw.DatumKey(encoded), true
+	}
+	key := make([]byte, 0, len(encoded))
+	for len(encoded) > 0 {
+		if encoded[0] != 0x00 {
+			key = append(key, encoded[0])
+			encoded = encoded[1:]
+			continue
+		}
+		if !bytes.HasPrefix(encoded, ssTableEscapedNUL) {
+			return "", false
+		}
+		key = append(key, 0x00)
+		encoded = encoded[len(ssTableEscapedNUL):]
+	}
+	return saw.DatumKey(key), true
+}
+
+func (dr *ssTableDatumReader) Close() error {
+	dr.iter.Release()
+	return dr.db.Close()
+}
+
+type ssTableDatumWriter struct {
+	db     *leveldb.DB
+	seq    uint64
+	keyBuf []byte
+}
+
+// Returns LevelDB key of datum with next sequence #, valid until next call.
+func (dw *ssTableDatumWriter) nextKey(key saw.DatumKey) []byte {
+	dw.keyBuf = dw.keyBuf[:0]
+	for i := 0; i < len(key); i++ {
+		if key[i] == 0x00 {
+			dw.keyBuf = append(dw.keyBuf, ssTableEscapedNUL...)
+		} else {
+			dw.keyBuf = append(dw.keyBuf, key[i])
+		}
+	}
+	dw.keyBuf = append(dw.keyBuf, ssTableKeyEnd...)
+	seqStart := len(dw.keyBuf)
+	dw.keyBuf = append(dw.keyBuf, make([]byte, ssTableSeqLen)...)
+	binary.BigEndian.PutUint64(dw.keyBuf[seqStart:], dw.seq)
+	dw.seq++
+	return dw.keyBuf
+}
+
+func (dw *ssTableDatumWriter) WriteDatum(datum saw.Datum) error {
+	return dw.db.Put(dw.nextKey(datum.Key), datum.Value.([]byte), nil)
+}
+
+// Writes datums in a single LevelDB batch.
+func (dw *ssTableDatumWriter) WriteDatums(datums []saw.Datum) error {
+	batch := new(leveldb.Batch)
+	for _, datum := range datums {
+		batch.Put(dw.nextKey(datum.Key), datum.Value.([]byte))
+	}
+	return dw.db.Write(batch, nil)
+}
+
+func (dw *ssTableDatumWriter) Close() error {
+	return dw.db.Close()
+}
+
+func init() {
+	RegisterStorageFormat("sstable", SSTableFormat{})
+}
@@ -8,6 +8,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"golang.org/x/net/context"
 )
@@ -25,9 +27,23 @@ type ResourceSpec struct {
 	Media     string
 	Path      string
 	NumShards int
+	// Size of read / write buffer of formats on top of IOReader / IOWriter,
+	// e.g. textio, recordio, defaultBufferSize when <= 0. Larger buffer, e.g.
+	// 64KiB, saves round trips on remote media like GCS.
+	BufferSize int
 }
 
-const localMediaName = "local"
+const (
+	localMediaName    = "local"
+	defaultBufferSize = 4096
+)
+
+func (rc *ResourceSpec) bufferSize() int {
+	if rc.BufferSize <= 0 {
+		return defaultBufferSize
+	}
+	return rc.BufferSize
+}
 
 func (rc *ResourceSpec) String() string {
 	var path = rc.Path
@@ -63,23 +79,64 @@ func (rc *ResourceSpec) ShardPath(shard int) string {
 // Returns io.ReaderCloser for Media specified in ResourceSpec, that can read
 // from specified shard, it would not points to local file system, or even not
 // points to a persistent storage (as consumer of message system eg.)
+//
+// When ctx is from WithReadCounter(), bytes read are added to the counter.
+//
+// When Media implements DecompressingMedia, data is decompressed by it
+// transparently, so all formats on top of IOReader read compressed input, e.g.
+// "textio:/gs/bucket/review.log.gz@4" on local and gs media. Bytes counted are
+// compressed bytes, comparable with Size().
 func (rc *ResourceSpec) IOReader(ctx context.Context, shard int) (io.ReadCloser, error) {
 	media, ok := storageMediaMap[rc.Media]
 	if !ok {
 		return nil, ErrUnknownStorageMedia
 	}
-	return media.IOReader(ctx, *rc, shard)
+	reader, err := media.IOReader(ctx, *rc, shard)
+	if err != nil {
+		return nil, err
+	}
+	if counter, ok := ctx.Value(readCounterKey{}).(*int64); ok {
+		reader = &countingReader{ReadCloser: reader, counter: counter}
+	}
+	if decompressing, ok := media.(DecompressingMedia); ok {
+		return decompressing.DecompressReader(ctx, *rc, shard, reader)
+	}
+	return reader, nil
+}
+
+// Returns size in bytes of specified shard, ErrStorageFeatureNotSupported
+// returns if Media doesn't implement SizedMedia.
+func (rc *ResourceSpec) Size(ctx context.Context, shard int) (int64, error) {
+	media, ok := storageMediaMap[rc.Media]
+	if !ok {
+		return 0, ErrUnknownStorageMedia
+	}
+	sized, ok := media.(SizedMedia)
+	if !ok {
+		return 0, ErrStorageFeatureNotSupported
+	}
+	return sized.Size(ctx, *rc, shard)
 }
 
 // Returns io.ReaderCloser for Media specified in ResourceSpec, that can write to
 // specified shard, it would not points to local file system, or even not points
 // to a persistent storage (emits to message system eg.)
+//
+// When Media implements CompressingMedia, data is compressed by it, matching
+// what IOReader() decompresses.
 func (rc *ResourceSpec) IOWriter(ctx context.Context, shard int) (io.WriteCloser, error) {
 	media, ok := storageMediaMap[rc.Media]
 	if !ok {
 		return nil, ErrUnknownStorageMedia
 	}
-	return media.IOWriter(ctx, *rc, shard)
+	writer, err := media.IOWriter(ctx, *rc, shard)
+	if err != nil {
+		return nil, err
+	}
+	if compressing, ok := media.(CompressingMedia); ok {
+		return compressing.CompressWriter(ctx, *rc, shard, writer)
+	}
+	return writer, nil
 }
 
 // Returns a DatumReader for format specified in ResourceSpec, it may or may not
@@ -104,6 +161,74 @@ func (rc *ResourceSpec) DatumWriter(ctx context.Context, shard int) (DatumWriter
 	return format.DatumWriter(ctx, *rc, shard)
 }
 
+// ParallelReadAll reads all shards with numWorkers concurrent readers, calls fn
+// for each datum, fn must be concurrent safe. Returns the first error from
+// readers or fn, which stops all readers, or ctx.Err() when ctx is done.
+func (rc *ResourceSpec) ParallelReadAll(
+	ctx context.Context, numWorkers int, fn func(datum saw.Datum) error) error {
+	numShards := 1
+	if rc.Sharded() {
+		numShards = rc.NumShards
+	}
+	if numWorkers <= 0 || numWorkers > numShards {
+		numWorkers = numShards
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	shards := make(chan int, numShards)
+	for i := 0; i < numShards; i++ {
+		shards <- i
+	}
+	close(shards)
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range shards {
+				if err := rc.readShard(ctx, shard, fn); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (rc *ResourceSpec) readShard(
+	ctx context.Context, shard int, fn func(datum saw.Datum) error) error {
+	reader, err := rc.DatumReader(ctx, shard)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		datum, err := reader.ReadDatum()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(datum); err != nil {
+			return err
+		}
+	}
+}
+
 // StorageFormat specifies how to read/write datum from underling StorageMedia
 // StorageMedia implementation can be globally regsitered by
 // RegisterStorageFormat()
@@ -120,9 +245,89 @@ type StorageMedia interface {
 	IOWriter(ctx context.Context, rc ResourceSpec, shard int) (io.WriteCloser, error)
 }
 
+// StorageMedia can optionally implement SizedMedia when size of a shard can be
+// known before reading it.
+type SizedMedia interface {
+	Size(ctx context.Context, rc ResourceSpec, shard int) (int64, error)
+}
+
+// StorageFormat can optionally implement SortedFormat when its DatumReader
+// reads datums of a shard in key order, datums of the same key in the order
+// they were written, e.g. sstable.
+type SortedFormat interface {
+	SortedByKey() bool
+}
+
+// Whether DatumReader of rc reads datums of a shard in key order, see
+// SortedFormat.
+func (rc *ResourceSpec) SortedByKey() bool {
+	format, ok := storageFormatMap[rc.Format].(SortedFormat)
+	return ok && format.SortedByKey()
+}
+
+// StorageMedia can optionally implement DecompressingMedia when data it stores
+// can be compressed, e.g. by path suffix, reads through ResourceSpec.IOReader(),
+// thus all formats on top of it, are decompressed by DecompressReader(). Media
+// not implementing it are read raw.
+type DecompressingMedia interface {
+	// Returns reader of decompressed data of reader, or reader itself when shard
+	// is not compressed. It takes ownership of reader, closing returned reader
+	// closes it, and it's closed when error.
+	DecompressReader(
+		ctx context.Context, rc ResourceSpec, shard int, reader io.ReadCloser) (io.ReadCloser, error)
+}
+
+// StorageMedia can optionally implement CompressingMedia, counterpart of
+// DecompressingMedia, so that data written through ResourceSpec.IOWriter() reads
+// back through IOReader().
+type CompressingMedia interface {
+	// Returns writer compressing data into writer, or writer itself when shard
+	// is not compressed. Closing returned writer closes writer.
+	CompressWriter(
+		ctx context.Context, rc ResourceSpec, shard int, writer io.WriteCloser) (io.WriteCloser, error)
+}
+
+type readCounterKey struct{}
+
+// Returns a context that makes IOReader() created with it atomically adds #
+// bytes read to counter, readers may read ahead with buffer, so it's only good
+// for progress estimation.
+func WithReadCounter(ctx context.Context, counter *int64) context.Context {
+	return context.WithValue(ctx, readCounterKey{}, counter)
+}
+
+type countingReader struct {
+	io.ReadCloser
+	counter *int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	atomic.AddInt64(cr.counter, int64(n))
+	return n, err
+}
+
+// MalformedRecordError is returned by DatumReader for a record it cannot parse,
+// the reader stays usable and next ReadDatum() reads the record after it.
+// Other errors, e.g. IO failures, end reading.
+type MalformedRecordError struct {
+	Err error
+}
+
+func (e *MalformedRecordError) Error() string {
+	return "malformed record: " + e.Err.Error()
+}
+
+// Whether err is a MalformedRecordError, reading can continue after it.
+func IsMalformedRecord(err error) bool {
+	_, ok := err.(*MalformedRecordError)
+	return ok
+}
+
 type DatumReader interface {
 	// Read next datum, implementation doesn't need to be concurrent safe. caller
-	// is expected to not further call it once an error is received.
+	// is expected to not further call it once an error is received, except
+	// io.EOF of input that may grow, e.g. a local file being appended.
 	ReadDatum() (saw.Datum, error)
 	Close() error
 }
@@ -134,6 +339,31 @@ type DatumWriter interface {
 	Close() error
 }
 
+// DatumWriter can optionally implement BatchDatumWriter when writing datums in
+// bulk is cheaper than one by one, e.g. a single LevelDB write batch.
+type BatchDatumWriter interface {
+	DatumWriter
+	// Writes all datums, caller can reuse datums and their values after return.
+	WriteDatums(datums []saw.Datum) error
+}
+
+// DatumWriter, or io.WriteCloser from StorageMedia, can optionally implement
+// ContextCloser when Close() may block on flushing, e.g. uploading to remote
+// media, so that it can be bounded by ctx deadline. CloseContext() returns
+// ctx.Err() when ctx is done before flush completes, data may be lost then.
+type ContextCloser interface {
+	CloseContext(ctx context.Context) error
+}
+
+// CloseContext closes c by CloseContext() if it implements ContextCloser,
+// otherwise Close(), ignoring ctx.
+func CloseContext(ctx context.Context, c io.Closer) error {
+	if cc, ok := c.(ContextCloser); ok {
+		return cc.CloseContext(ctx)
+	}
+	return c.Close()
+}
+
 var (
 	storageFormatMap = make(map[string]StorageFormat)
 	storageMediaMap  = make(map[string]StorageMedia)
@@ -155,7 +385,7 @@ func RegisterStorageMedia(name string, media StorageMedia) {
 	storageMediaMap[name] = media
 }
 
-var resourcePathPattern = regexp.MustCompile("^([^\\s]+)\\:([^@\\s]+)(@\\d+)?$")
+var resourcePathPattern = regexp.MustCompile("^([^\\s:]+)\\:([^@\\s]+)(@\\d+)?$")
 
 // A resource path has the format: format:{path}{@numShards}?
 // format and media should already be registered.
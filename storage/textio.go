@@ -23,7 +23,7 @@ func (tf TextFormat) DatumReader(
 	return &textDatumReader{
 		key:      saw.DatumKey(strconv.Itoa(shard)),
 		internal: f,
-		reader:   bufio.NewReader(f),
+		reader:   bufio.NewReaderSize(f, rc.bufferSize()),
 	}, nil
 }
 
@@ -33,20 +33,30 @@ func (tf TextFormat) DatumWriter(
 	if err != nil {
 		return nil, err
 	}
-	// TODO: check media and decide whether to buffer write, maybe for GCS, don't
-	// need yet another buffer.
-	return &textDatumWriter{internal: f, writer: bufio.NewWriter(f)}, nil
+	return &textDatumWriter{internal: f, writer: bufio.NewWriterSize(f, rc.bufferSize())}, nil
 }
 
 type textDatumReader struct {
 	key      saw.DatumKey
 	internal io.ReadCloser
 	reader   *bufio.Reader
+	// Incomplete last line before io.EOF, completed by reading again when input
+	// grows.
+	partial []byte
 }
 
 func (dr *textDatumReader) ReadDatum() (datum saw.Datum, err error) {
 	datum.Key = dr.key
-	datum.Value, err = dr.reader.ReadBytes('\n')
+	line, err := dr.reader.ReadBytes('\n')
+	if dr.partial != nil {
+		line = append(dr.partial, line...)
+		dr.partial = nil
+	}
+	if err == io.EOF && len(line) > 0 {
+		dr.partial = line
+		line = nil
+	}
+	datum.Value = line
 	return
 }
 
@@ -67,7 +77,17 @@ func (dw *textDatumWriter) WriteDatum(datum saw.Datum) error {
 }
 
 func (dw *textDatumWriter) Close() error {
-	return dw.internal.Close()
+	return dw.CloseContext(context.Background())
+}
+
+// Flushes buffered lines and closes underlying writer, a flush error doesn't
+// prevent closing.
+func (dw *textDatumWriter) CloseContext(ctx context.Context) error {
+	flushErr := dw.writer.Flush()
+	if err := CloseContext(ctx, dw.internal); err != nil {
+		return err
+	}
+	return flushErr
 }
 
 func init() {
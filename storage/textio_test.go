@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+// Binary proto values, newlines included, survive a textio file through
+// Base64Codec.
+func TestTextBase64ProtoRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "textio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rc := MustParseResourcePath("textio:" + filepath.Join(dir, "data"))
+	codec := saw.Base64Codec{
+		Encoder: saw.ProtoEncoder{},
+		Decoder: saw.NewProtoDecoder(&wrappers.BytesValue{}),
+	}
+	values := [][]byte{[]byte("a\nb"), {0, '\n', '\r', 0xff}, {}}
+
+	writer, err := rc.DatumWriter(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range values {
+		encoded, err := codec.EncodeValue(&wrappers.BytesValue{Value: value}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.WriteDatum(saw.Datum{Value: encoded}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := rc.DatumReader(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for i, want := range values {
+		datum, err := reader.ReadDatum()
+		if err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		decoded, err := codec.DecodeValue(datum.Value.([]byte))
+		if err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if got := decoded.(*wrappers.BytesValue).Value; !bytes.Equal(got, want) {
+			t.Errorf("line %d = %q, want %q", i, got, want)
+		}
+	}
+	if _, err := reader.ReadDatum(); err != io.EOF {
+		t.Errorf("ReadDatum() after last line returns %v, want io.EOF", err)
+	}
+}
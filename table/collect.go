@@ -1,12 +1,32 @@
 package table
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
 	"github.com/kuangyh/saw"
 	"github.com/kuangyh/saw/storage"
 	"golang.org/x/net/context"
-	"sync"
 )
 
+var ErrTableClosed = errors.New("saw.table: table closed")
+
+// PartialFlushError returns from CollectTable.Result() when ctx is done before
+// all shards are flushed and closed, output of Shards may be incomplete.
+type PartialFlushError struct {
+	Resource storage.ResourceSpec
+	// Shards not completed, in order.
+	Shards []int
+	Err    error
+}
+
+func (e *PartialFlushError) Error() string {
+	return fmt.Sprintf(
+		"saw.table: shards %v of %s not flushed: %v", e.Shards, e.Resource.String(), e.Err)
+}
+
 // Encode and write to shard, conforms to saw.DatumWriter but should not be use
 // externally
 type shardDatumWriter struct {
@@ -14,15 +34,15 @@ type shardDatumWriter struct {
 	mu           sync.Mutex
 	valueEncoder saw.ValueEncoder
 	encodeBuffer []byte
+	batchSize    int
+	pending      []saw.Datum
+	// Set by CloseContext(), writes after it return ErrTableClosed.
+	closed bool
 }
 
-func (shard *shardDatumWriter) WriteDatum(datum saw.Datum) (err error) {
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
-
+func (shard *shardDatumWriter) write(datum saw.Datum) error {
 	if shard.valueEncoder != nil {
-		var encoded []byte
-		encoded, err = shard.valueEncoder.EncodeValue(datum.Value, shard.encodeBuffer)
+		encoded, err := shard.valueEncoder.EncodeValue(datum.Value, shard.encodeBuffer)
 		if err != nil {
 			return err
 		}
@@ -31,8 +51,91 @@ func (shard *shardDatumWriter) WriteDatum(datum saw.Datum) (err error) {
 	return shard.internal.WriteDatum(datum)
 }
 
+// Encodes datum value into a buffer of its own, so that datum can be buffered
+// while caller reuses its value.
+func (shard *shardDatumWriter) encode(datum saw.Datum) (saw.Datum, error) {
+	if shard.valueEncoder == nil {
+		datum.Value = append([]byte(nil), datum.Value.([]byte)...)
+		return datum, nil
+	}
+	encoded, err := shard.valueEncoder.EncodeValue(datum.Value, nil)
+	if err != nil {
+		return datum, err
+	}
+	datum.Value = encoded
+	return datum, nil
+}
+
+// Writes all pending datums, already encoded, in a single batch when writer
+// implements storage.BatchDatumWriter. Pending datums are dropped even when
+// error.
+func (shard *shardDatumWriter) flush() error {
+	if len(shard.pending) == 0 {
+		return nil
+	}
+	var lastErr error
+	if batchWriter, ok := shard.internal.(storage.BatchDatumWriter); ok {
+		lastErr = batchWriter.WriteDatums(shard.pending)
+	} else {
+		for _, datum := range shard.pending {
+			if err := shard.internal.WriteDatum(datum); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	shard.pending = shard.pending[:0]
+	return lastErr
+}
+
+// When batchSize > 1, datum is encoded right away, outside of lock, then
+// buffered and written with others in bulk. Encoding errors return for the
+// datum itself, error of writing a batch returns from the WriteDatum() or
+// Close() that flushes it.
+func (shard *shardDatumWriter) WriteDatum(datum saw.Datum) error {
+	if shard.batchSize <= 1 {
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+		if shard.closed {
+			return ErrTableClosed
+		}
+		return shard.write(datum)
+	}
+	datum, err := shard.encode(datum)
+	if err != nil {
+		return err
+	}
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.closed {
+		return ErrTableClosed
+	}
+	shard.pending = append(shard.pending, datum)
+	if len(shard.pending) < shard.batchSize {
+		return nil
+	}
+	return shard.flush()
+}
+
 func (shard *shardDatumWriter) Close() error {
-	return shard.internal.Close()
+	return shard.CloseContext(context.Background())
+}
+
+// Flushes pending datums and closes underlying writer, closing is bounded by
+// ctx when the writer implements storage.ContextCloser. Writes racing with it
+// either complete before it or return ErrTableClosed.
+func (shard *shardDatumWriter) CloseContext(ctx context.Context) error {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.closed {
+		return ErrTableClosed
+	}
+	shard.closed = true
+
+	flushErr := shard.flush()
+	if err := storage.CloseContext(ctx, shard.internal); err != nil {
+		return err
+	}
+	return flushErr
 }
 
 // Collect is a special table that it doesn't do any computation, but simply
@@ -40,6 +143,8 @@ func (shard *shardDatumWriter) Close() error {
 type CollectTable struct {
 	spec     TableSpec
 	shards   []*shardDatumWriter
+	closed   int32
+	routed   uint32
 	countVar saw.VarInt
 	errVar   saw.VarInt
 }
@@ -51,7 +156,9 @@ type CollectTable struct {
 // soley determined by PersistentResource.NumShards
 //
 func NewCollectTable(ctx context.Context, spec TableSpec) (table *CollectTable, err error) {
-	fillSpecDefaults(&spec)
+	if err := fillSpecDefaults(&spec); err != nil {
+		return nil, err
+	}
 
 	var numShards int
 	if spec.PersistentResource.Sharded() {
@@ -59,6 +166,9 @@ func NewCollectTable(ctx context.Context, spec TableSpec) (table *CollectTable,
 	} else {
 		numShards = 1
 	}
+	if spec.SSTableOptions != nil {
+		ctx = storage.WithSSTableOptions(ctx, *spec.SSTableOptions)
+	}
 	internalWriters := make([]storage.DatumWriter, numShards)
 	for i := 0; i < numShards; i++ {
 		internalWriters[i], err = spec.PersistentResource.DatumWriter(ctx, i)
@@ -75,6 +185,10 @@ func NewCollectTable(ctx context.Context, spec TableSpec) (table *CollectTable,
 			internal:     internal,
 			valueEncoder: spec.ValueEncoder,
 			encodeBuffer: make([]byte, spec.ValueEncodeBufferSize),
+			batchSize:    spec.WriteBatchSize,
+		}
+		if spec.WriteBatchSize > 1 {
+			shards[i].pending = make([]saw.Datum, 0, spec.WriteBatchSize)
 		}
 	}
 	return &CollectTable{
@@ -85,9 +199,14 @@ func NewCollectTable(ctx context.Context, spec TableSpec) (table *CollectTable,
 	}, nil
 }
 
+// Emit writes datum to one of the shards, returns ErrTableClosed after Result()
+// called, including Emit() concurrent with Result() that reaches a shard after
+// it's closed.
 func (tbl *CollectTable) Emit(datum saw.Datum) (err error) {
-	shardIdx := tbl.spec.KeyHashFunc(datum.Key) % len(tbl.shards)
-	err = tbl.shards[shardIdx].WriteDatum(datum)
+	if atomic.LoadInt32(&tbl.closed) != 0 {
+		return ErrTableClosed
+	}
+	err = tbl.shards[tbl.shardIdx(datum.Key)].WriteDatum(datum)
 	tbl.countVar.Add(1)
 	if err != nil {
 		tbl.errVar.Add(1)
@@ -95,11 +214,69 @@ func (tbl *CollectTable) Emit(datum saw.Datum) (err error) {
 	return err
 }
 
+type shardCloseResult struct {
+	shard int
+	err   error
+}
+
+func (tbl *CollectTable) shardIdx(key saw.DatumKey) int {
+	if tbl.spec.ShardStrategy == ShardRoundRobin {
+		return int((atomic.AddUint32(&tbl.routed, 1) - 1) % uint32(len(tbl.shards)))
+	}
+	return shardOf(tbl.spec.KeyHashFunc, key, len(tbl.shards))
+}
+
+// Result flushes and closes all shards and returns PersistentResource the table
+// writes to, one of the errors returns if any shard fails. CollectTable cannot be
+// reused after Result(), further calls to Emit() or Result() returns
+// ErrTableClosed.
+//
+// Shards are closed in parallel, bounded by ctx deadline where media supports
+// it (see storage.ContextCloser), e.g. to cooperate with shutdown timeout. When
+// ctx is done before all shards complete, Result() returns *PartialFlushError
+// naming them, those still flushing continue in background.
 func (tbl *CollectTable) Result(ctx context.Context) (interface{}, error) {
-	for _, shard := range tbl.shards {
-		shard.Close()
+	if !atomic.CompareAndSwapInt32(&tbl.closed, 0, 1) {
+		return nil, ErrTableClosed
+	}
+	results := make(chan shardCloseResult, len(tbl.shards))
+	for i, shard := range tbl.shards {
+		go func(i int, shard *shardDatumWriter) {
+			results <- shardCloseResult{shard: i, err: shard.CloseContext(ctx)}
+		}(i, shard)
 	}
-	// panic if reuse
-	tbl.shards = nil
-	return tbl.spec.PersistentResource, nil
+	completed := make([]bool, len(tbl.shards))
+	var lastErr error
+	for pending := len(tbl.shards); pending > 0; pending-- {
+		var result shardCloseResult
+		select {
+		case result = <-results:
+		case <-ctx.Done():
+			return tbl.spec.PersistentResource, tbl.partialFlushError(ctx, completed)
+		}
+		if result.err != nil {
+			tbl.errVar.Add(1)
+			lastErr = result.err
+		}
+		// Shards failed by ctx are not completed.
+		completed[result.shard] = result.err == nil || result.err != ctx.Err()
+	}
+	if ctx.Err() != nil {
+		for _, done := range completed {
+			if !done {
+				return tbl.spec.PersistentResource, tbl.partialFlushError(ctx, completed)
+			}
+		}
+	}
+	return tbl.spec.PersistentResource, lastErr
+}
+
+func (tbl *CollectTable) partialFlushError(ctx context.Context, completed []bool) error {
+	err := &PartialFlushError{Resource: tbl.spec.PersistentResource, Err: ctx.Err()}
+	for i, done := range completed {
+		if !done {
+			err.Shards = append(err.Shards, i)
+		}
+	}
+	return err
 }
@@ -0,0 +1,149 @@
+package table
+
+import (
+	"io"
+	"sort"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+// NewCollectReader reads output of a CollectTable back as datums, so that a job
+// can consume what a prior job collected, e.g. as RunBatch input through a
+// DatumReader.
+//
+// Shards of rc are read one after another. CollectTable puts all datums of a
+// key in the same shard, the reader emits a shard grouped by key in key order,
+// values of a key in the order they were written. Formats already sorted by key
+// (see storage.SortedFormat), e.g. sstable, are streamed; others are sorted by
+// loading a whole shard in memory. Values are decoded by valueDecoder, kept as
+// []byte when nil.
+//
+// Output of CollectTable with ShardRoundRobin spreads datums of a key across
+// shards, the reader can't tell, datums of a key are then grouped per shard
+// only.
+func NewCollectReader(
+	ctx context.Context, rc storage.ResourceSpec,
+	valueDecoder saw.ValueDecoder) (storage.DatumReader, error) {
+	if !rc.HasSpec() {
+		return nil, ErrInvalidTableSpec
+	}
+	numShards := 1
+	if rc.Sharded() {
+		numShards = rc.NumShards
+	}
+	return &collectReader{
+		ctx:          ctx,
+		rc:           rc,
+		valueDecoder: valueDecoder,
+		numShards:    numShards,
+		sorted:       rc.SortedByKey(),
+	}, nil
+}
+
+type collectReader struct {
+	ctx          context.Context
+	rc           storage.ResourceSpec
+	valueDecoder saw.ValueDecoder
+	numShards    int
+	// Whether shards are read in key order, streamed without loading.
+	sorted bool
+	// Next shard to open.
+	nextShard int
+	// Reader of the shard being streamed, nil when not streaming.
+	reader storage.DatumReader
+	// Datums of the loaded shard not yet read.
+	pending []saw.Datum
+}
+
+type datumKeySort []saw.Datum
+
+func (ds datumKeySort) Len() int           { return len(ds) }
+func (ds datumKeySort) Less(i, j int) bool { return ds[i].Key < ds[j].Key }
+func (ds datumKeySort) Swap(i, j int)      { t := ds[i]; ds[i] = ds[j]; ds[j] = t }
+
+func (cr *collectReader) decode(datum saw.Datum) (saw.Datum, error) {
+	if cr.valueDecoder == nil {
+		return datum, nil
+	}
+	var err error
+	datum.Value, err = cr.valueDecoder.DecodeValue(datum.Value.([]byte))
+	return datum, err
+}
+
+// Opens shard for streaming when sorted, otherwise loads and sorts it.
+func (cr *collectReader) openShard(shard int) error {
+	reader, err := cr.rc.DatumReader(cr.ctx, shard)
+	if err != nil {
+		return err
+	}
+	if cr.sorted {
+		cr.reader = reader
+		return nil
+	}
+	defer reader.Close()
+
+	cr.pending = nil
+	for {
+		datum, err := reader.ReadDatum()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if datum, err = cr.decode(datum); err != nil {
+			return err
+		}
+		cr.pending = append(cr.pending, datum)
+	}
+	sort.Stable(datumKeySort(cr.pending))
+	return nil
+}
+
+func (cr *collectReader) ReadDatum() (saw.Datum, error) {
+	for {
+		if cr.reader != nil {
+			datum, err := cr.reader.ReadDatum()
+			if err == io.EOF {
+				err = cr.reader.Close()
+				cr.reader = nil
+				if err != nil {
+					return saw.Datum{}, err
+				}
+				continue
+			}
+			if err != nil {
+				return saw.Datum{}, err
+			}
+			return cr.decode(datum)
+		}
+		if len(cr.pending) > 0 {
+			datum := cr.pending[0]
+			cr.pending = cr.pending[1:]
+			return datum, nil
+		}
+		if cr.nextShard >= cr.numShards {
+			return saw.Datum{}, io.EOF
+		}
+		if err := cr.ctx.Err(); err != nil {
+			return saw.Datum{}, err
+		}
+		if err := cr.openShard(cr.nextShard); err != nil {
+			return saw.Datum{}, err
+		}
+		cr.nextShard++
+	}
+}
+
+func (cr *collectReader) Close() error {
+	cr.pending = nil
+	cr.nextShard = cr.numShards
+	if cr.reader != nil {
+		err := cr.reader.Close()
+		cr.reader = nil
+		return err
+	}
+	return nil
+}
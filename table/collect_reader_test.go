@@ -0,0 +1,87 @@
+package table
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+// Collect output is read back grouped by key in key order within a shard,
+// values of a key in the order written, for both streamed (sstable) and
+// loaded (recordkv) formats.
+func TestCollectReaderKeyOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collectreader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	emits := []saw.Datum{
+		{Key: "c", Value: "c0"}, {Key: "a", Value: "a0"}, {Key: "b", Value: "b0"},
+		{Key: "a", Value: "a1"}, {Key: "c", Value: "c1"}, {Key: "a", Value: "a2"},
+		{Key: "d", Value: "d0"}, {Key: "b", Value: "b1"},
+	}
+	for _, format := range []string{"sstable", "recordkv"} {
+		rc := storage.MustParseResourcePath(
+			format + ":" + filepath.Join(dir, format) + "@2")
+		tbl, err := NewCollectTable(context.Background(), TableSpec{
+			Name:               "collectReader" + format,
+			PersistentResource: rc,
+			ValueEncoder:       stringEncoder{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, datum := range emits {
+			if err := tbl.Emit(datum); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := tbl.Result(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := NewCollectReader(context.Background(), rc, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var read []saw.Datum
+		for {
+			datum, err := reader.ReadDatum()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			read = append(read, datum)
+		}
+		if err := reader.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if len(read) != len(emits) {
+			t.Fatalf("%s: read %d datums, want %d", format, len(read), len(emits))
+		}
+
+		// Values of each key in write order, keys ascending within a shard.
+		seen := make(map[saw.DatumKey]int)
+		var last saw.DatumKey
+		for i, datum := range read {
+			value := string(datum.Value.([]byte))
+			if want := string(datum.Key) + string(rune('0'+seen[datum.Key])); value != want {
+				t.Errorf("%s: value %d of %s = %q, want %q", format, i, datum.Key, value, want)
+			}
+			if i > 0 && datum.Key != last && seen[datum.Key] > 0 {
+				t.Errorf("%s: datums of %s not grouped", format, datum.Key)
+			}
+			seen[datum.Key]++
+			last = datum.Key
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+var errTestEncode = errors.New("bad value")
+
+type stringEncoder struct{}
+
+func (se stringEncoder) EncodeValue(value interface{}, buf []byte) ([]byte, error) {
+	if value == "bad" {
+		return nil, errTestEncode
+	}
+	return append(buf[:0], value.(string)...), nil
+}
+
+func readCollected(t *testing.T, rc storage.ResourceSpec) map[saw.DatumKey]string {
+	reader, err := NewCollectReader(context.Background(), rc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	values := make(map[saw.DatumKey]string)
+	for {
+		datum, err := reader.ReadDatum()
+		if err == io.EOF {
+			return values
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values[datum.Key] = string(datum.Value.([]byte))
+	}
+}
+
+// Buffered datums don't keep values caller reuses after Emit().
+func TestCollectTableBatchOwnsValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rc := storage.MustParseResourcePath("recordkv:" + filepath.Join(dir, "out") + "@2")
+	tbl, err := NewCollectTable(context.Background(), TableSpec{
+		Name:               "collectOwnsValues",
+		PersistentResource: rc,
+		WriteBatchSize:     4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	keys := []saw.DatumKey{"a", "b", "c", "d", "e", "f", "g"}
+	for i, key := range keys {
+		buf[0] = byte('0' + i)
+		if err := tbl.Emit(saw.Datum{Key: key, Value: buf}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tbl.Result(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	values := readCollected(t, rc)
+	for i, key := range keys {
+		if want := string(rune('0' + i)); values[key] != want {
+			t.Errorf("value of %s = %q, want %q", key, values[key], want)
+		}
+	}
+}
+
+// Encoding error returns from Emit() of the datum, not the one flushing the
+// batch.
+func TestCollectTableBatchEncodeError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rc := storage.MustParseResourcePath("recordkv:" + filepath.Join(dir, "out"))
+	tbl, err := NewCollectTable(context.Background(), TableSpec{
+		Name:               "collectEncodeError",
+		PersistentResource: rc,
+		ValueEncoder:       stringEncoder{},
+		WriteBatchSize:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Emit(saw.Datum{Key: "a", Value: "bad"}); err != errTestEncode {
+		t.Errorf("Emit() of bad value returns %v, want %v", err, errTestEncode)
+	}
+	for _, key := range []saw.DatumKey{"b", "c"} {
+		if err := tbl.Emit(saw.Datum{Key: key, Value: "good"}); err != nil {
+			t.Errorf("Emit() of good value returns %v", err)
+		}
+	}
+	if _, err := tbl.Result(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	values := readCollected(t, rc)
+	if len(values) != 2 || values["b"] != "good" || values["c"] != "good" {
+		t.Errorf("collected %v, want b and c", values)
+	}
+}
+
+// Collects 2MiB into sstable with options, returns # of LevelDB table files.
+func countSSTableFiles(t *testing.T, dir string, options *storage.SSTableOptions) int {
+	rc := storage.MustParseResourcePath("sstable:" + dir)
+	tbl, err := NewCollectTable(context.Background(), TableSpec{
+		Name:               "collectSSTableOptions",
+		PersistentResource: rc,
+		SSTableOptions:     options,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 1024)
+	for i := 0; i < 2048; i++ {
+		if err := tbl.Emit(saw.Datum{Key: saw.DatumKey(strconv.Itoa(i)), Value: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tbl.Result(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(rc.ShardPath(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	numTables := 0
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".ldb") {
+			numTables++
+		}
+	}
+	return numTables
+}
+
+// TableSpec.SSTableOptions reaches LevelDB: a small WriteBuffer flushes table
+// files the default 16MiB one keeps in memory.
+func TestCollectTableSSTableOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if n := countSSTableFiles(t, filepath.Join(dir, "default"), nil); n != 0 {
+		t.Errorf("default options write %d table files, want 0", n)
+	}
+	small := &storage.SSTableOptions{WriteBuffer: 64 << 10, CompactionTableSize: 64 << 10}
+	if n := countSSTableFiles(t, filepath.Join(dir, "small"), small); n == 0 {
+		t.Error("small WriteBuffer writes no table file")
+	}
+}
+
+// Emit() racing with Result() either writes its datum before the shard closes
+// or returns ErrTableClosed.
+func TestCollectTableEmitDuringResult(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, batchSize := range []int{0, 4} {
+		rc := storage.MustParseResourcePath(
+			"recordkv:" + filepath.Join(dir, strconv.Itoa(batchSize)) + "@4")
+		tbl, err := NewCollectTable(context.Background(), TableSpec{
+			Name:               "collectEmitDuringResult",
+			PersistentResource: rc,
+			WriteBatchSize:     batchSize,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		const numWorkers = 4
+		written := make([]int, numWorkers)
+		errs := make(chan error, numWorkers)
+		var started sync.WaitGroup
+		started.Add(numWorkers)
+		for w := 0; w < numWorkers; w++ {
+			go func(w int) {
+				for i := 0; ; i++ {
+					if i == 100 {
+						started.Done()
+					}
+					key := saw.DatumKey(fmt.Sprintf("%d-%d", w, i))
+					err := tbl.Emit(saw.Datum{Key: key, Value: []byte("x")})
+					if err != nil {
+						errs <- err
+						return
+					}
+					written[w]++
+				}
+			}(w)
+		}
+		started.Wait()
+		if _, err := tbl.Result(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		total := 0
+		for w := 0; w < numWorkers; w++ {
+			if err := <-errs; err != ErrTableClosed {
+				t.Errorf("Emit() during Result() returns %v, want %v", err, ErrTableClosed)
+			}
+		}
+		for _, n := range written {
+			total += n
+		}
+		if n := len(readCollected(t, rc)); n != total {
+			t.Errorf("batch size %d: collected %d datums, want %d", batchSize, n, total)
+		}
+	}
+}
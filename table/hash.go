@@ -0,0 +1,156 @@
+package table
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math/bits"
+	"sync"
+
+	"github.com/kuangyh/saw"
+)
+
+var (
+	keyHashLock  sync.Mutex
+	keyHashFuncs = map[string]KeyHashFunc{
+		"fnv32":  defaultGetKeyHash,
+		"fnv64":  fnv64KeyHash,
+		"crc32":  crc32KeyHash,
+		"xxhash": xxhashKeyHash,
+	}
+)
+
+// RegisterKeyHash registers hash function under name, so that it can be
+// selected by TableSpec.KeyHashName. Built-ins are "fnv32" (default), "fnv64",
+// "crc32" and "xxhash" (XXH64). Panics when hashFunc is nil or name is taken,
+// should be run in init().
+func RegisterKeyHash(name string, hashFunc KeyHashFunc) {
+	if hashFunc == nil {
+		panic("nil key hash " + name)
+	}
+	keyHashLock.Lock()
+	defer keyHashLock.Unlock()
+	if _, ok := keyHashFuncs[name]; ok {
+		panic("duplicated key hash " + name)
+	}
+	keyHashFuncs[name] = hashFunc
+}
+
+// UnknownKeyHashError returns from table constructors when
+// TableSpec.KeyHashName is not registered, it unwraps to ErrInvalidTableSpec.
+type UnknownKeyHashError struct {
+	Name string
+}
+
+func (e *UnknownKeyHashError) Error() string {
+	return fmt.Sprintf("%v: unknown key hash %q", ErrInvalidTableSpec, e.Name)
+}
+
+func (e *UnknownKeyHashError) Unwrap() error {
+	return ErrInvalidTableSpec
+}
+
+func keyHashByName(name string) (KeyHashFunc, error) {
+	keyHashLock.Lock()
+	defer keyHashLock.Unlock()
+	hashFunc, ok := keyHashFuncs[name]
+	if !ok {
+		return nil, &UnknownKeyHashError{Name: name}
+	}
+	return hashFunc, nil
+}
+
+// Sets KeyHashFunc of spec by KeyHashName when it's nil.
+func resolveKeyHash(spec *TableSpec) error {
+	if spec.KeyHashFunc != nil || spec.KeyHashName == "" {
+		return nil
+	}
+	hashFunc, err := keyHashByName(spec.KeyHashName)
+	if err != nil {
+		return err
+	}
+	spec.KeyHashFunc = hashFunc
+	return nil
+}
+
+func fnv64KeyHash(key saw.DatumKey) int {
+	hash := fnv.New64()
+	hash.Write([]byte(key))
+	return int(hash.Sum64())
+}
+
+func crc32KeyHash(key saw.DatumKey) int {
+	return int(crc32.ChecksumIEEE([]byte(key)))
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+// XXH64 with seed 0.
+func xxhashKeyHash(key saw.DatumKey) int {
+	b := []byte(key)
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		// Seed is 0, wraps around as XXH64 does.
+		p1, p2 := xxPrime1, xxPrime2
+		v1 := p1 + p2
+		v2 := p2
+		v3 := uint64(0)
+		v4 := -p1
+		for len(b) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+			b = b[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return int(h)
+}
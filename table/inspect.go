@@ -0,0 +1,37 @@
+package table
+
+import (
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+type InspectResultCallback func(key saw.DatumKey, result interface{}) error
+
+// InspectResult inspects item of key in tbl by its current result rather than
+// the live saw: Export() snapshot for items implementing saw.ExportSaw, which
+// is non-destructive, otherwise Result(ctx).
+//
+// Falling back to Result() is only safe for saws whose Result() doesn't
+// release resources, finalize state or return internal state later Emit()
+// modifies; for others, e.g. a CollectTable as item, implement saw.ExportSaw to
+// make inspection of a live table safe. Aggregators in aggregator package
+// implement saw.ExportSaw returning copies of their state.
+func InspectResult(
+	ctx context.Context, tbl Inspectable, key saw.DatumKey,
+	callback InspectResultCallback) (int, error) {
+	return tbl.Inspect(key, func(key saw.DatumKey, item saw.Saw) error {
+		result, err := currentResult(ctx, item)
+		if err != nil {
+			return err
+		}
+		return callback(key, result)
+	})
+}
+
+// Export() of item when it implements saw.ExportSaw, otherwise Result(ctx).
+func currentResult(ctx context.Context, item saw.Saw) (interface{}, error) {
+	if exportable, ok := item.(saw.ExportSaw); ok {
+		return exportable.Export()
+	}
+	return item.Result(ctx)
+}
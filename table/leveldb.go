@@ -0,0 +1,337 @@
+package table
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"github.com/kuangyh/saw"
+	"github.com/syndtr/goleveldb/leveldb"
+	"golang.org/x/net/context"
+)
+
+var ErrItemNotExportable = errors.New("saw.table: item not exportable")
+
+type levelDBCacheEntry struct {
+	key  saw.DatumKey
+	item saw.Saw
+}
+
+// LevelDBTable is a concurrent safe table that stores item states in a local
+// LevelDB, it's for correctness when set of keys exceeds memory, not for speed.
+//
+// Item saws must implement saw.ExportSaw, their Export() are encoded by
+// spec.ValueEncoder then stored in LevelDB. When an item is needed again, stored
+// state is decoded by valueDecoder and fed to a new item created by
+// spec.ItemFactory, through spec.ItemRestorer (MergeFrom() by default).
+//
+// A small LRU cache of live items is kept in memory, items are written back
+// when evicted. All operations are serialized by a single lock, expects
+// throughput much lower than MemTable.
+type LevelDBTable struct {
+	spec         TableSpec
+	valueDecoder saw.ValueDecoder
+	db           *leveldb.DB
+
+	mu           sync.Mutex
+	cacheSize    int
+	cache        map[saw.DatumKey]*list.Element
+	lru          *list.List
+	encodeBuffer []byte
+
+	numKeysVar    saw.VarInt
+	errVar        saw.VarInt
+	cacheMissVar  saw.VarInt
+	writeBacksVar saw.VarInt
+}
+
+// Creates or opens LevelDB at path for storing item states, cacheSize is max #
+// items kept in memory, defaults to 1024.
+func NewLevelDBTable(
+	spec TableSpec, path string, valueDecoder saw.ValueDecoder, cacheSize int) (*LevelDBTable, error) {
+	if err := fillSpecDefaults(&spec); err != nil {
+		return nil, err
+	}
+	if spec.ValueEncoder == nil || valueDecoder == nil {
+		return nil, ErrInvalidTableSpec
+	}
+	if spec.ItemRestorer == nil {
+		spec.ItemRestorer = mergeRestore
+	}
+	if spec.ValueEncodeBufferSize == 0 {
+		spec.ValueEncodeBufferSize = 4096
+	}
+	if cacheSize <= 0 {
+		cacheSize = 1024
+	}
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDBTable{
+		spec:          spec,
+		valueDecoder:  valueDecoder,
+		db:            db,
+		cacheSize:     cacheSize,
+		cache:         make(map[saw.DatumKey]*list.Element),
+		lru:           list.New(),
+		encodeBuffer:  make([]byte, spec.ValueEncodeBufferSize),
+		numKeysVar:    saw.ReportInt(spec.Name, "keys"),
+		errVar:        saw.ReportInt(spec.Name, "errors"),
+		cacheMissVar:  saw.ReportInt(spec.Name, "cacheMiss"),
+		writeBacksVar: saw.ReportInt(spec.Name, "writeBacks"),
+	}, nil
+}
+
+func (tbl *LevelDBTable) writeBack(key saw.DatumKey, item saw.Saw) error {
+	exportable, ok := item.(saw.ExportSaw)
+	if !ok {
+		return ErrItemNotExportable
+	}
+	state, err := exportable.Export()
+	if err != nil {
+		return err
+	}
+	encoded, err := tbl.spec.ValueEncoder.EncodeValue(state, tbl.encodeBuffer)
+	if err != nil {
+		return err
+	}
+	tbl.writeBacksVar.Add(1)
+	return tbl.db.Put([]byte(key), encoded, nil)
+}
+
+// Restores item from stored state, returns nil item if key not stored.
+func (tbl *LevelDBTable) restore(key saw.DatumKey) (saw.Saw, error) {
+	data, err := tbl.db.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := tbl.valueDecoder.DecodeValue(data)
+	if err != nil {
+		return nil, err
+	}
+	item, err := tbl.spec.ItemFactory(tbl.spec.Name, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := tbl.spec.ItemRestorer(item, value); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Gets item from cache or storage, creates a new one when create is true, or
+// returns nil item.
+func (tbl *LevelDBTable) item(key saw.DatumKey, create bool) (saw.Saw, error) {
+	if elem, ok := tbl.cache[key]; ok {
+		tbl.lru.MoveToFront(elem)
+		return elem.Value.(*levelDBCacheEntry).item, nil
+	}
+	tbl.cacheMissVar.Add(1)
+	item, err := tbl.restore(key)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		if !create {
+			return nil, nil
+		}
+		if item, err = tbl.spec.ItemFactory(tbl.spec.Name, key); err != nil {
+			return nil, err
+		}
+		tbl.numKeysVar.Add(1)
+	}
+	tbl.cache[key] = tbl.lru.PushFront(&levelDBCacheEntry{key: key, item: item})
+	for tbl.lru.Len() > tbl.cacheSize {
+		elem := tbl.lru.Back()
+		entry := elem.Value.(*levelDBCacheEntry)
+		// Keeps it in cache so that state is not lost.
+		if err := tbl.writeBack(entry.key, entry.item); err != nil {
+			return item, err
+		}
+		tbl.lru.Remove(elem)
+		delete(tbl.cache, entry.key)
+	}
+	return item, nil
+}
+
+// Writes back all cached items and empties cache.
+func (tbl *LevelDBTable) flush() error {
+	for tbl.lru.Len() > 0 {
+		elem := tbl.lru.Back()
+		entry := elem.Value.(*levelDBCacheEntry)
+		if err := tbl.writeBack(entry.key, entry.item); err != nil {
+			return err
+		}
+		tbl.lru.Remove(elem)
+		delete(tbl.cache, entry.key)
+	}
+	return nil
+}
+
+func (tbl *LevelDBTable) Emit(kv saw.Datum) error {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	item, err := tbl.item(kv.Key, true)
+	if item == nil {
+		return err
+	}
+	if err := item.Emit(kv); err != nil {
+		tbl.errVar.Add(1)
+		return err
+	}
+	return err
+}
+
+func (tbl *LevelDBTable) inspectOrCreate(key saw.DatumKey, callback InspectCallback) error {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	item, err := tbl.item(key, true)
+	if item == nil {
+		return err
+	}
+	if err := callback(key, item); err != nil {
+		return err
+	}
+	return err
+}
+
+func (tbl *LevelDBTable) Inspect(key saw.DatumKey, callback InspectCallback) (int, error) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	return tbl.inspect(key, callback)
+}
+
+func (tbl *LevelDBTable) inspect(key saw.DatumKey, callback InspectCallback) (int, error) {
+	item, err := tbl.item(key, false)
+	if item == nil {
+		return 0, err
+	}
+	if err := callback(key, item); err != nil {
+		return 0, err
+	}
+	return 1, err
+}
+
+// Inspects keys one by one, concurrent is no-op.
+func (tbl *LevelDBTable) InspectSet(
+	keys []saw.DatumKey, callback InspectCallback, concurrent bool) (int, error) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	total := 0
+	for _, key := range keys {
+		n, err := tbl.inspect(key, callback)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Inspects all items in key order, concurrent is no-op.
+func (tbl *LevelDBTable) InspectAll(callback InspectCallback, concurrent bool) (int, error) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	if err := tbl.flush(); err != nil {
+		return 0, err
+	}
+	iter := tbl.db.NewIterator(nil, nil)
+	defer iter.Release()
+	total := 0
+	for iter.Next() {
+		n, err := tbl.inspect(saw.DatumKey(iter.Key()), callback)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, iter.Error()
+}
+
+// Returns TableResultMap, each item as Result() of item saw. nil item results are
+// ignored. Underling LevelDB is closed after Result(), it's not resumable.
+//
+// When error presents in individual items Result(), it still tries to get results
+// of all others, then a partial result and one of the item result error will be
+// returned.
+//
+// When tbl.spec.PersistentResource set, results are written to persistent store
+// in key order instead, without holding them in memory, Result() returns
+// ResultStreamStats then, like MemTable.ResultStream().
+func (tbl *LevelDBTable) Result(ctx context.Context) (interface{}, error) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	defer tbl.db.Close()
+
+	if err := tbl.flush(); err != nil {
+		return nil, err
+	}
+	if tbl.spec.PersistentResource.HasSpec() {
+		return tbl.resultStream(ctx)
+	}
+	resultMap := make(TableResultMap)
+	_, err := tbl.forEachResult(ctx, func(key saw.DatumKey, v interface{}) error {
+		resultMap[key] = v
+		return nil
+	})
+	return resultMap, err
+}
+
+func (tbl *LevelDBTable) resultStream(ctx context.Context) (ResultStreamStats, error) {
+	var stats ResultStreamStats
+	collectTableSpec := tbl.spec
+	collectTableSpec.Name = collectTableSpec.Name + "_collect"
+	collectTable, err := NewCollectTable(ctx, collectTableSpec)
+	if err != nil {
+		return stats, err
+	}
+	stats.Errors, err = tbl.forEachResult(ctx, func(key saw.DatumKey, v interface{}) error {
+		if err := collectTable.Emit(saw.Datum{Key: key, Value: v}); err != nil {
+			return err
+		}
+		stats.Count++
+		return nil
+	})
+	if _, closeErr := collectTable.Result(ctx); closeErr != nil {
+		err = closeErr
+	}
+	return stats, err
+}
+
+// Calls fn with Result() of stored items in key order, skipping nil results.
+// Failed items, including failures of fn, are counted and skipped, one of the
+// errors returns.
+func (tbl *LevelDBTable) forEachResult(
+	ctx context.Context, fn func(key saw.DatumKey, v interface{}) error) (int64, error) {
+	var numErrors int64
+	var lastErr error
+	iter := tbl.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := saw.DatumKey(iter.Key())
+		item, err := tbl.restore(key)
+		var v interface{}
+		if err == nil {
+			v, err = item.Result(ctx)
+		}
+		if err == nil && v != nil {
+			err = fn(key, v)
+		}
+		if err != nil {
+			numErrors++
+			lastErr = err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		lastErr = err
+	}
+	return numErrors, lastErr
+}
@@ -0,0 +1,91 @@
+package table
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/aggregator"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+func newTestLevelDBTable(t *testing.T, dir string, spec TableSpec) *LevelDBTable {
+	spec.ItemFactory = ItemFactoryOf(&aggregator.Sum{})
+	spec.ValueEncoder = saw.JSONEncoder{}
+	tbl, err := NewLevelDBTable(
+		spec, filepath.Join(dir, "db"), saw.NewJSONDecoder(&aggregator.Sum{}), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []saw.DatumKey{"a", "b", "c", "d", "e"}
+	for i := 0; i < 50; i++ {
+		if err := tbl.Emit(saw.Datum{Key: keys[i%len(keys)], Value: i % len(keys)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tbl
+}
+
+// Aggregators evicted from cache are written back by Export() and restored by
+// MergeFrom().
+func TestLevelDBTableAggregators(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tbl := newTestLevelDBTable(t, dir, TableSpec{Name: "levelDBAggregators"})
+	result, err := tbl.Result(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultMap := result.(TableResultMap)
+	for i, key := range []saw.DatumKey{"a", "b", "c", "d", "e"} {
+		if got, want := resultMap[key], aggregator.Metric(i*10); got != want {
+			t.Errorf("result of %s = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestLevelDBTableResultStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rc := storage.MustParseResourcePath("recordkv:" + filepath.Join(dir, "out") + "@2")
+	tbl := newTestLevelDBTable(
+		t, dir, TableSpec{Name: "levelDBResultStream", PersistentResource: rc})
+	result, err := tbl.Result(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := result.(ResultStreamStats); stats.Count != 5 || stats.Errors != 0 {
+		t.Errorf("Result() returns %+v, want 5 written", stats)
+	}
+
+	reader, err := NewCollectReader(
+		context.Background(), rc, saw.NewJSONDecoder(new(aggregator.Metric)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	got := make(map[saw.DatumKey]aggregator.Metric)
+	for {
+		datum, err := reader.ReadDatum()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[datum.Key] = *datum.Value.(*aggregator.Metric)
+	}
+	if len(got) != 5 || got["a"] != 0 || got["e"] != 40 {
+		t.Errorf("persisted results %v, want 5 keys, a: 0, e: 40", got)
+	}
+}
@@ -0,0 +1,94 @@
+package table
+
+import (
+	"io"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+func mergeRestore(item saw.Saw, value interface{}) error {
+	mergeable, ok := item.(saw.MergeSaw)
+	if !ok {
+		return ErrItemNotRestorable
+	}
+	return mergeable.MergeFrom(value)
+}
+
+// LoadMemTable creates a MemTable and seeds it with data previously persisted
+// to spec.PersistentResource, by MemTable.Result() or a CollectTable.
+//
+// For every persisted datum, value is decoded by valueDecoder (kept as []byte
+// when nil), then item saw of the key is created by spec.ItemFactory and handed
+// to spec.ItemRestorer with the decoded value.
+//
+// Note that MemTable persists Result() of its items, which is normally not the
+// full state of a saw --- you cannot get back a Mean from its mean value. Full
+// state restore requires values persisted are Export() of item saws, and items
+// can MergeFrom() them, e.g. aggregators persisted by PersistSnapshot(), decoded
+// to type of their Export(). Otherwise, ItemRestorer has to decide how a Result()
+// value seeds the item, and computation on top of it may be approximate.
+func LoadMemTable(
+	ctx context.Context, spec TableSpec, valueDecoder saw.ValueDecoder) (*MemTable, error) {
+	if !spec.PersistentResource.HasSpec() {
+		return nil, ErrInvalidTableSpec
+	}
+	if err := resolveKeyHash(&spec); err != nil {
+		return nil, err
+	}
+	tbl := NewMemTable(spec)
+	if tbl.spec.ItemRestorer == nil {
+		tbl.spec.ItemRestorer = mergeRestore
+	}
+	numShards := 1
+	if tbl.spec.PersistentResource.Sharded() {
+		numShards = tbl.spec.PersistentResource.NumShards
+	}
+	for i := 0; i < numShards; i++ {
+		if err := tbl.loadShard(ctx, tbl.spec.PersistentResource, i, valueDecoder); err != nil {
+			return nil, err
+		}
+	}
+	return tbl, nil
+}
+
+func (tbl *MemTable) loadShard(
+	ctx context.Context, rc storage.ResourceSpec, shard int, valueDecoder saw.ValueDecoder) error {
+	reader, err := rc.DatumReader(ctx, shard)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		datum, err := reader.ReadDatum()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		value := datum.Value
+		if valueDecoder != nil {
+			if value, err = valueDecoder.DecodeValue(datum.Value.([]byte)); err != nil {
+				return err
+			}
+		}
+		if err := tbl.restore(datum.Key, value); err != nil {
+			return err
+		}
+	}
+}
+
+func (tbl *MemTable) restore(key saw.DatumKey, value interface{}) error {
+	shardIdx := shardOf(tbl.spec.KeyHashFunc, key, len(tbl.shards))
+	tbl.locks[shardIdx].Lock()
+	defer tbl.locks[shardIdx].Unlock()
+
+	item, err := tbl.shards[shardIdx].item(key)
+	if err != nil {
+		return err
+	}
+	return tbl.spec.ItemRestorer(item, value)
+}
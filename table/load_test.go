@@ -0,0 +1,57 @@
+package table
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/aggregator"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+// Aggregators restore their full state from PersistSnapshot() through the
+// default ItemRestorer.
+func TestLoadMemTableRestoresAggregators(t *testing.T) {
+	dir, err := ioutil.TempDir("", "load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	spec := TableSpec{
+		Name:         "loadAggregators",
+		ItemFactory:  ItemFactoryOf(&aggregator.Moments{}),
+		NumShards:    4,
+		ValueEncoder: saw.JSONEncoder{},
+	}
+	tbl := NewMemTable(spec)
+	for i := 0; i < 10; i++ {
+		tbl.Emit(saw.Datum{Key: saw.DatumKey([]string{"a", "b"}[i%2]), Value: i})
+	}
+	rc := storage.MustParseResourcePath("recordkv:" + dir + "/snapshot@2")
+	if _, err := tbl.PersistSnapshot(ctx, rc); err != nil {
+		t.Fatal(err)
+	}
+
+	spec.PersistentResource = rc
+	loaded, err := LoadMemTable(ctx, spec, saw.NewJSONDecoder(&aggregator.MomentsExport{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 10; i < 20; i++ {
+		loaded.Emit(saw.Datum{Key: saw.DatumKey([]string{"a", "b"}[i%2]), Value: i})
+	}
+	n, err := loaded.Inspect("a", func(key saw.DatumKey, item saw.Saw) error {
+		state, _ := item.Result(ctx)
+		moments := state.(aggregator.MomentsState)
+		if moments.Count() != 10 || moments.Mean() != 9 {
+			t.Errorf("restored moments count=%v mean=%v, want 10, 9", moments.Count(), moments.Mean())
+		}
+		return nil
+	})
+	if n != 1 || err != nil {
+		t.Errorf("Inspect() returns %d, %v, want 1, nil", n, err)
+	}
+}
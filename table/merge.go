@@ -0,0 +1,67 @@
+package table
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/kuangyh/saw"
+)
+
+var (
+	ErrItemNotFound  = errors.New("saw.table: item not found")
+	ErrMergeIntoSelf = errors.New("saw.table: merge table into itself")
+)
+
+// Tables in this package can create item for a key being inspected.
+type itemCreator interface {
+	inspectOrCreate(key saw.DatumKey, callback InspectCallback) error
+}
+
+// MergeInto merges every item in src into item of the same key in dst, by
+// calling dst item's MergeFrom() with src item's Export(). It enables map-reduce
+// style computation: partial tables built from different inputs get merged to
+// a final one.
+//
+// Items in both tables must implement saw.MergeSaw and saw.ExportSaw, or it
+// errors. When a key only exists in src, dst item is created by its own
+// ItemFactory if dst is one of tables in this package, otherwise
+// ErrItemNotFound returns.
+//
+// MergeInto stops as soon as an error is encountered, leaving dst partially
+// merged.
+//
+// dst and src must not share items or their locks, src is inspected under its
+// locks while dst items are merged. ErrMergeIntoSelf returns when dst is src,
+// other sharing, e.g. a table and its shard, deadlocks.
+func MergeInto(dst, src Inspectable) error {
+	if reflect.TypeOf(dst).Comparable() && dst == src {
+		return ErrMergeIntoSelf
+	}
+	creator, _ := dst.(itemCreator)
+	_, err := src.InspectAll(func(key saw.DatumKey, srcItem saw.Saw) error {
+		exportable, ok := srcItem.(saw.ExportSaw)
+		if !ok {
+			return ErrItemNotExportable
+		}
+		state, err := exportable.Export()
+		if err != nil {
+			return err
+		}
+		merge := func(key saw.DatumKey, dstItem saw.Saw) error {
+			mergeable, ok := dstItem.(saw.MergeSaw)
+			if !ok {
+				return ErrNotMergeable
+			}
+			return mergeable.MergeFrom(state)
+		}
+		if creator != nil {
+			return creator.inspectOrCreate(key, merge)
+		}
+		n, err := dst.Inspect(key, merge)
+		if err == nil && n == 0 {
+			err = ErrItemNotFound
+		}
+		return err
+	}, false)
+	return err
+}
@@ -0,0 +1,58 @@
+package table
+
+import (
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/aggregator"
+	"golang.org/x/net/context"
+)
+
+// Partial tables of aggregators on different inputs merge into a final one,
+// keys only in src are created in dst.
+func TestMergeIntoAggregators(t *testing.T) {
+	newPartial := func(keys ...saw.DatumKey) *MemTable {
+		tbl := NewMemTable(TableSpec{
+			Name:        "mergeAggregators",
+			ItemFactory: ItemFactoryOf(&aggregator.GroupCount{}),
+			NumShards:   4,
+		})
+		for _, key := range keys {
+			tbl.Emit(saw.Datum{Key: key, Value: "x"})
+		}
+		return tbl
+	}
+	dst := newPartial("a", "b", "b")
+	if err := MergeInto(dst, newPartial("b", "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := MergeInto(dst, newPartial("c", "c")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := dst.Result(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[saw.DatumKey]int64{"a": 1, "b": 3, "c": 3}
+	resultMap := result.(TableResultMap)
+	if len(resultMap) != len(want) {
+		t.Errorf("merged %d items, want %d", len(resultMap), len(want))
+	}
+	for key, count := range want {
+		if got := resultMap[key].(map[string]int64)["x"]; got != count {
+			t.Errorf("merged count of %s = %d, want %d", key, got, count)
+		}
+	}
+}
+
+// Merging a table into itself errors instead of deadlock.
+func TestMergeIntoSelf(t *testing.T) {
+	tbl := NewMemTable(TableSpec{
+		Name:        "mergeIntoSelf",
+		ItemFactory: ItemFactoryOf(&aggregator.GroupCount{}),
+	})
+	tbl.Emit(saw.Datum{Key: "a", Value: "x"})
+	if err := MergeInto(tbl, tbl); err != ErrMergeIntoSelf {
+		t.Errorf("MergeInto() returns %v, want %v", err, ErrMergeIntoSelf)
+	}
+}
@@ -0,0 +1,54 @@
+package table
+
+import (
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+// ReduceResources is the reduce side of map-reduce: it reads outputs of
+// multiple tables, e.g. MemTable.PersistSnapshot() of RunBatch jobs on
+// different inputs, merges values of the same key into one item, and writes a
+// single reduced output to spec.PersistentResource.
+//
+// Values of inputs are decoded by valueDecoder (kept as []byte when nil), then
+// seeded into item of the key created by spec.ItemFactory by spec.ItemRestorer,
+// defaults to MergeFrom() of items implementing saw.MergeSaw. Items are written
+// the same way as PersistSnapshot(), by Export() when implemented, so that the
+// output can be reduced again; values are encoded by spec.ValueEncoder.
+//
+// All items are held in memory until written. Loading stops at the first
+// error; when writing, like MemTable.ResultStream(), it tries to write all
+// items and returns one of the errors.
+func ReduceResources(
+	ctx context.Context, spec TableSpec, inputs []storage.ResourceSpec,
+	valueDecoder saw.ValueDecoder) (ResultStreamStats, error) {
+	if !spec.PersistentResource.HasSpec() {
+		return ResultStreamStats{}, ErrInvalidTableSpec
+	}
+	if err := resolveKeyHash(&spec); err != nil {
+		return ResultStreamStats{}, err
+	}
+	tbl := NewMemTable(spec)
+	if tbl.spec.ItemRestorer == nil {
+		tbl.spec.ItemRestorer = mergeRestore
+	}
+	for _, input := range inputs {
+		if !input.HasSpec() {
+			return ResultStreamStats{}, ErrInvalidTableSpec
+		}
+		numShards := 1
+		if input.Sharded() {
+			numShards = input.NumShards
+		}
+		for i := 0; i < numShards; i++ {
+			if err := ctx.Err(); err != nil {
+				return ResultStreamStats{}, err
+			}
+			if err := tbl.loadShard(ctx, input, i, valueDecoder); err != nil {
+				return ResultStreamStats{}, err
+			}
+		}
+	}
+	return tbl.persistItems(ctx, spec.PersistentResource, "_reduce", currentResult)
+}
@@ -0,0 +1,77 @@
+package table
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/aggregator"
+	"github.com/kuangyh/saw/storage"
+	"golang.org/x/net/context"
+)
+
+// Snapshots of aggregator tables on different inputs reduce into one, and the
+// reduced output can be reduced again.
+func TestReduceResourcesAggregators(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reduce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	spec := TableSpec{
+		Name:         "reduceAggregators",
+		ItemFactory:  ItemFactoryOf(&aggregator.Sum{}),
+		NumShards:    4,
+		ValueEncoder: saw.JSONEncoder{},
+	}
+	var inputs []storage.ResourceSpec
+	for j := 0; j < 3; j++ {
+		tbl := NewMemTable(spec)
+		for i := 0; i < 10; i++ {
+			tbl.Emit(saw.Datum{Key: saw.DatumKey([]string{"a", "b"}[i%2]), Value: j})
+		}
+		rc := storage.MustParseResourcePath(
+			"recordkv:" + filepath.Join(dir, "map"+string(rune('0'+j))) + "@2")
+		if _, err := tbl.PersistSnapshot(ctx, rc); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, rc)
+	}
+	decoder := saw.NewJSONDecoder(&aggregator.Sum{})
+
+	spec.PersistentResource = storage.MustParseResourcePath("recordkv:" + filepath.Join(dir, "reduced"))
+	stats, err := ReduceResources(ctx, spec, inputs[:2], decoder)
+	if err != nil || stats.Count != 2 {
+		t.Fatalf("ReduceResources() returns %+v, %v, want 2 items", stats, err)
+	}
+	inputs = []storage.ResourceSpec{spec.PersistentResource, inputs[2]}
+	spec.PersistentResource = storage.MustParseResourcePath("recordkv:" + filepath.Join(dir, "final"))
+	if _, err := ReduceResources(ctx, spec, inputs, decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewCollectReader(ctx, spec.PersistentResource, decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	got := make(map[saw.DatumKey]aggregator.Metric)
+	for {
+		datum, err := reader.ReadDatum()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[datum.Key] = datum.Value.(*aggregator.Sum).Current
+	}
+	// Each input adds j five times to each key.
+	if len(got) != 2 || got["a"] != 15 || got["b"] != 15 {
+		t.Errorf("reduced sums %v, want a: 15, b: 15", got)
+	}
+}
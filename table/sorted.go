@@ -0,0 +1,134 @@
+package table
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/kuangyh/saw"
+	"golang.org/x/net/context"
+)
+
+// SortedTable is a concurrent safe in-memory table that keeps its keys sorted,
+// all inspections visit items in key order and Result() returns results in key
+// order.
+//
+// All operations are serialized by a single lock, and inserting a new key costs
+// O(N), it's designed for moderate number of keys where ordered output matters,
+// time-bucketed keys for reporting etc. Use MemTable for large tables.
+type SortedTable struct {
+	mu    sync.Mutex
+	items *SimpleTable
+	keys  []saw.DatumKey
+}
+
+func NewSortedTable(spec TableSpec) *SortedTable {
+	return &SortedTable{items: NewSimpleTable(spec)}
+}
+
+// Index of the first key >= key.
+func (tbl *SortedTable) search(key saw.DatumKey) int {
+	return sort.Search(len(tbl.keys), func(i int) bool { return tbl.keys[i] >= key })
+}
+
+func (tbl *SortedTable) item(key saw.DatumKey) (saw.Saw, error) {
+	if item, ok := tbl.items.items[key]; ok {
+		return item, nil
+	}
+	item, err := tbl.items.item(key)
+	if err != nil {
+		return nil, err
+	}
+	idx := tbl.search(key)
+	tbl.keys = append(tbl.keys, "")
+	copy(tbl.keys[idx+1:], tbl.keys[idx:])
+	tbl.keys[idx] = key
+	return item, nil
+}
+
+func (tbl *SortedTable) Emit(kv saw.Datum) error {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	if _, err := tbl.item(kv.Key); err != nil {
+		return err
+	}
+	return tbl.items.Emit(kv)
+}
+
+func (tbl *SortedTable) inspectOrCreate(key saw.DatumKey, callback InspectCallback) error {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	item, err := tbl.item(key)
+	if err != nil {
+		return err
+	}
+	return callback(key, item)
+}
+
+func (tbl *SortedTable) Inspect(key saw.DatumKey, callback InspectCallback) (int, error) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	return tbl.items.Inspect(key, callback)
+}
+
+// Inspects keys in the order of keys slice, concurrent is no-op.
+func (tbl *SortedTable) InspectSet(
+	keys []saw.DatumKey, callback InspectCallback, concurrent bool) (int, error) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	return tbl.items.InspectSet(keys, callback, false)
+}
+
+// Inspects all items in key order, concurrent is no-op.
+func (tbl *SortedTable) InspectAll(callback InspectCallback, concurrent bool) (int, error) {
+	return tbl.InspectRange("", "", callback)
+}
+
+// Inspects items with key in [start, end) in key order, empty end means no upper
+// bound.
+func (tbl *SortedTable) InspectRange(
+	start, end saw.DatumKey, callback InspectCallback) (int, error) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	tbl.items.Flush()
+	total := 0
+	for i := tbl.search(start); i < len(tbl.keys); i++ {
+		key := tbl.keys[i]
+		if len(end) > 0 && key >= end {
+			break
+		}
+		if err := callback(key, tbl.items.items[key]); err != nil {
+			return total, err
+		}
+		total++
+	}
+	return total, nil
+}
+
+// Returns []saw.Datum sorted by key, with each item's Result() as value. nil
+// item results are ignored.
+//
+// Like SimpleTable, when error presents in individual items Result(), it still
+// tries to get results of all others, then a partial result and one of the item
+// result error will be returned.
+func (tbl *SortedTable) Result(ctx context.Context) (interface{}, error) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+
+	lastErr := tbl.items.Flush()
+	result := make([]saw.Datum, 0, len(tbl.keys))
+	for _, key := range tbl.keys {
+		v, err := tbl.items.items[key].Result(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if v == nil {
+			continue
+		}
+		result = append(result, saw.Datum{Key: key, Value: v})
+	}
+	return result, lastErr
+}
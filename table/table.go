@@ -2,16 +2,25 @@ package table
 
 import (
 	"errors"
+	"fmt"
 	"github.com/kuangyh/saw"
 	"github.com/kuangyh/saw/storage"
 	"golang.org/x/net/context"
 	"hash/fnv"
+	"log"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 )
 
-var ErrInvalidTableSpec = errors.New("saw.table: invalid table spec")
+var (
+	ErrInvalidTableSpec  = errors.New("saw.table: invalid table spec")
+	ErrItemNotRestorable = errors.New("saw.table: item not restorable")
+	ErrNotMergeable      = errors.New("saw.table: item not mergeable")
+)
 
 type KeyHashFunc func(saw.DatumKey) int
 
@@ -20,15 +29,64 @@ type TableItemFactory func(tableName string, key saw.DatumKey) (saw.Saw, error)
 // A simple item factory that creates zero value (not copy!) instance of saw type
 // in paramter, panic if saw is not pointer receiver.
 func ItemFactoryOf(example saw.Saw) TableItemFactory {
-	instanceType := reflect.TypeOf(example).Elem()
+	exampleType := reflect.TypeOf(example)
+	if exampleType == nil || exampleType.Kind() != reflect.Ptr {
+		panic(fmt.Sprintf(
+			"saw.table: ItemFactoryOf requires pointer to saw, got %v", exampleType))
+	}
+	instanceType := exampleType.Elem()
 	return func(tableName string, key saw.DatumKey) (saw.Saw, error) {
 		return reflect.New(instanceType).Interface().(saw.Saw), nil
 	}
 }
 
+// Item factory creates item saws by calling make, for saws need constructor
+// arguments, e.g. ItemFactoryOfFunc(func() saw.Saw { return aggregator.NewQuantile(...) }).
+func ItemFactoryOfFunc(make func() saw.Saw) TableItemFactory {
+	return func(tableName string, key saw.DatumKey) (saw.Saw, error) {
+		return make(), nil
+	}
+}
+
+// ItemFactoryFunc adapts make to TableItemFactory, it's the preferred factory
+// for parameterized aggregators, when item saw depends on key or construction
+// can fail, e.g.
+//
+//	ItemFactoryFunc(func(name string, key saw.DatumKey) (saw.Saw, error) {
+//	  return aggregator.NewQuantile(numBuckets, samplesPerBucket), nil
+//	})
+func ItemFactoryFunc(
+	make func(tableName string, key saw.DatumKey) (saw.Saw, error)) TableItemFactory {
+	return TableItemFactory(make)
+}
+
+// ShardStrategy decides how CollectTable assigns datums to output shards.
+type ShardStrategy int
+
+const (
+	// Assigns by KeyHashFunc of datum.Key, all datums of a key are in the same
+	// shard, but shards are as skewed as keys.
+	ShardByKeyHash ShardStrategy = iota
+	// Assigns datums to shards in turns, so that shards are of even size
+	// regardless of key distribution, for pure collection. Datums of a key are
+	// spread across shards, NewCollectReader() no longer reads them together.
+	ShardRoundRobin
+)
+
+// Stages of errors reported to TableSpec.OnError.
+const (
+	// Item creation by ItemFactory failed.
+	ErrorStageFactory = "factory"
+	// Emit() of item failed.
+	ErrorStageEmit = "emit"
+)
+
 // SimpleTable and MemTable result type
 type TableResultMap map[saw.DatumKey]interface{}
 
+// Seeds item saw with value loaded from persistent storage, see LoadMemTable().
+type ItemRestoreFunc func(item saw.Saw, value interface{}) error
+
 type InspectCallback func(key saw.DatumKey, item saw.Saw) error
 
 // Inspectable tables allows a callback to inspect one, a set of, or all saws
@@ -56,8 +114,17 @@ type TableSpec struct {
 
 	// KeyHashFunc assigns incoming Datum to one of its shard, defaults to fnv32
 	KeyHashFunc KeyHashFunc
-	// Defaults to 127
+	// Name of hash function registered by RegisterKeyHash(), used when
+	// KeyHashFunc is nil, for config-driven pipelines. An unknown name fails
+	// constructors returning error with *UnknownKeyHashError, others log it and
+	// use the default.
+	KeyHashName string
+	// Defaults to 127. Power of two shard counts route by bit mask, cheaper
+	// than modulo, but then shards are only as good as low bits of key hash.
 	NumShards int
+	// Max # of shards processed concurrently by Result() and concurrent
+	// inspections, defaults to GOMAXPROCS.
+	MaxShardConcurrency int
 
 	// When not empty, table state will be stored at external storage.
 	PersistentResource storage.ResourceSpec
@@ -67,6 +134,35 @@ type TableSpec struct {
 	// Implementation may pre-allocate and reuse buffer for encoding values, to avoid
 	// frequent malloc, defaults to 4096
 	ValueEncodeBufferSize int
+	// When > 1, tables writing to PersistentResource buffer datums and write them
+	// to each shard in batches of this size, defaults to 0 (no buffering). A
+	// batch is a single write for formats implementing storage.BatchDatumWriter,
+	// e.g. sstable.
+	WriteBatchSize int
+	// LevelDB tuning when PersistentResource is of sstable format, defaults to
+	// options of the registered format.
+	SSTableOptions *storage.SSTableOptions
+	// How CollectTable assigns datums to shards of PersistentResource, defaults
+	// to ShardByKeyHash.
+	ShardStrategy ShardStrategy
+	// Called by SimpleTable and MemTable on errors of items, with key, stage
+	// (ErrorStageFactory or ErrorStageEmit) and the error, e.g. to alert
+	// differently. Must be concurrent safe for MemTable.
+	OnError func(key saw.DatumKey, stage string, err error)
+	// By default, a key failed in item creation is banned, the same error
+	// returns for its later Emit(). When true, ItemFactory is called again on
+	// next Emit() of the key instead, for transient failures.
+	RetryFactory bool
+	// When true, SimpleTable and MemTable buffer datums of each key instead of
+	// emitting them right away, and replay them to the item in datum.SortOrder
+	// (stable for ties) at flush points: Flush(), inspections and Result(), for
+	// order-sensitive items like first / last value. All datums emitted between
+	// flush points are kept in memory, flush periodically for long-running
+	// tables. Emit errors are reported when replayed, not returned by Emit().
+	BufferAndSort bool
+	// Used by LoadMemTable() to seed newly created item saws with persisted values,
+	// defaults to call MergeFrom() of items implementing saw.MergeSaw.
+	ItemRestorer ItemRestoreFunc
 }
 
 func defaultGetKeyHash(key saw.DatumKey) int {
@@ -75,66 +171,178 @@ func defaultGetKeyHash(key saw.DatumKey) int {
 	return int(hash.Sum32())
 }
 
-func fillSpecDefaults(spec *TableSpec) {
+// Maps key to one of numShards shards, hash is taken as unsigned so that a custom
+// KeyHashFunc returning negative values is safe. When numShards is a power of
+// two, low bits of hash are masked instead of modulo.
+func shardOf(hashFunc KeyHashFunc, key saw.DatumKey, numShards int) int {
+	n := uint(numShards)
+	if n&(n-1) == 0 {
+		return int(uint(hashFunc(key)) & (n - 1))
+	}
+	return int(uint(hashFunc(key)) % n)
+}
+
+// Returns error of resolving KeyHashName, spec is filled with default key hash
+// then.
+func fillSpecDefaults(spec *TableSpec) error {
+	err := resolveKeyHash(spec)
 	if spec.KeyHashFunc == nil {
 		spec.KeyHashFunc = defaultGetKeyHash
 	}
 	if spec.NumShards == 0 {
 		spec.NumShards = 127
 	}
+	if spec.MaxShardConcurrency <= 0 {
+		spec.MaxShardConcurrency = runtime.GOMAXPROCS(0)
+	}
 	if spec.PersistentResource.HasSpec() {
 		if spec.ValueEncodeBufferSize == 0 {
 			spec.ValueEncodeBufferSize = 4096
 		}
 	}
+	return err
 }
 
 // SimpleTable is a in-memory, non-storable memory table, concurrent non-safe
 // table --- it assumes you call all its methods sequentially. Good for handling
 // small set of data in mini-batch --- aggregate stats for a single user session
 // etc.
+//
+// Item errors are counted in <spec.Name>.factoryErrors and emitErrors by stage,
+// and in total in errors.
 type SimpleTable struct {
-	spec       TableSpec
-	items      map[saw.DatumKey]saw.Saw
-	banned     map[saw.DatumKey]error
-	numKeysVar saw.VarInt
-	errVar     saw.VarInt
+	spec          TableSpec
+	items         map[saw.DatumKey]saw.Saw
+	banned        map[saw.DatumKey]error
+	pending       map[saw.DatumKey][]saw.Datum
+	numKeysVar    saw.VarInt
+	errVar        saw.VarInt
+	factoryErrVar saw.VarInt
+	emitErrVar    saw.VarInt
+	// Set when table is a shard of MemTable, counts keys by shard index.
+	shardKeysVar saw.VarMap
+	shardLabel   string
 }
 
 func NewSimpleTable(spec TableSpec) *SimpleTable {
-	fillSpecDefaults(&spec)
+	if err := fillSpecDefaults(&spec); err != nil {
+		log.Printf("%v, table %s uses default key hash", err, spec.Name)
+	}
 	return &SimpleTable{
-		spec:       spec,
-		items:      make(map[saw.DatumKey]saw.Saw),
-		banned:     make(map[saw.DatumKey]error),
-		numKeysVar: saw.ReportInt(spec.Name, "keys"),
-		errVar:     saw.ReportInt(spec.Name, "errors"),
+		spec:          spec,
+		items:         make(map[saw.DatumKey]saw.Saw),
+		banned:        make(map[saw.DatumKey]error),
+		pending:       make(map[saw.DatumKey][]saw.Datum),
+		numKeysVar:    saw.ReportInt(spec.Name, "keys"),
+		errVar:        saw.ReportInt(spec.Name, "errors"),
+		factoryErrVar: saw.ReportInt(spec.Name, "factoryErrors"),
+		emitErrVar:    saw.ReportInt(spec.Name, "emitErrors"),
+	}
+}
+
+func (tbl *SimpleTable) reportError(key saw.DatumKey, stage string, err error) {
+	tbl.errVar.Add(1)
+	if stage == ErrorStageFactory {
+		tbl.factoryErrVar.Add(1)
+	} else {
+		tbl.emitErrVar.Add(1)
+	}
+	if tbl.spec.OnError != nil {
+		tbl.spec.OnError(key, stage, err)
 	}
 }
 
+// Gets item saw for key, creates one with spec.ItemFactory if not exists. Keys
+// failed in item creation are banned unless spec.RetryFactory, the same error
+// is returned afterwards.
+func (tbl *SimpleTable) item(key saw.DatumKey) (saw.Saw, error) {
+	saw, ok := tbl.items[key]
+	if ok {
+		return saw, nil
+	}
+	if err, banned := tbl.banned[key]; banned {
+		return nil, err
+	}
+	saw, err := tbl.spec.ItemFactory(tbl.spec.Name, key)
+	if err != nil {
+		tbl.reportError(key, ErrorStageFactory, err)
+		if !tbl.spec.RetryFactory {
+			tbl.banned[key] = err
+		}
+		return nil, err
+	}
+	tbl.items[key] = saw
+	tbl.numKeysVar.Add(1)
+	if tbl.shardKeysVar != nil {
+		tbl.shardKeysVar.AddLabeled(tbl.shardLabel, 1)
+	}
+	return saw, nil
+}
+
 func (tbl *SimpleTable) Emit(kv saw.Datum) (err error) {
-	saw, ok := tbl.items[kv.Key]
-	if !ok {
-		if err, banned := tbl.banned[kv.Key]; banned {
-			return err
+	saw, err := tbl.item(kv.Key)
+	if err != nil {
+		return err
+	}
+	if tbl.spec.BufferAndSort {
+		tbl.pending[kv.Key] = append(tbl.pending[kv.Key], kv)
+		return nil
+	}
+	return tbl.emitItem(saw, kv)
+}
+
+func (tbl *SimpleTable) emitItem(item saw.Saw, kv saw.Datum) error {
+	err := item.Emit(kv)
+	if err != nil {
+		tbl.reportError(kv.Key, ErrorStageEmit, err)
+	}
+	return err
+}
+
+// Replays buffered datums of key to its item in SortOrder, returns one of
+// Emit() errors.
+func (tbl *SimpleTable) flushKey(key saw.DatumKey) error {
+	data := tbl.pending[key]
+	if len(data) == 0 {
+		return nil
+	}
+	delete(tbl.pending, key)
+	sort.SliceStable(data, func(i, j int) bool { return data[i].SortOrder < data[j].SortOrder })
+	item := tbl.items[key]
+	var lastErr error
+	for _, datum := range data {
+		if err := tbl.emitItem(item, datum); err != nil {
+			lastErr = err
 		}
-		var err error
-		saw, err = tbl.spec.ItemFactory(tbl.spec.Name, kv.Key)
-		if err != nil {
-			tbl.banned[kv.Key] = err
-			return err
+	}
+	return lastErr
+}
+
+// Flush emits datums buffered when spec.BufferAndSort to their items, in
+// SortOrder of each key, e.g. at end of a mini-batch. Returns one of Emit()
+// errors, all of them are reported in emitErrors. No-op otherwise.
+func (tbl *SimpleTable) Flush() error {
+	var lastErr error
+	for key := range tbl.pending {
+		if err := tbl.flushKey(key); err != nil {
+			lastErr = err
 		}
-		tbl.items[kv.Key] = saw
-		tbl.numKeysVar.Add(1)
 	}
-	err = saw.Emit(kv)
+	return lastErr
+}
+
+func (tbl *SimpleTable) inspectOrCreate(key saw.DatumKey, callback InspectCallback) error {
+	saw, err := tbl.item(key)
 	if err != nil {
-		tbl.errVar.Add(1)
+		return err
 	}
-	return err
+	// Errors are reported in emitErrors, item is inspected regardless.
+	tbl.flushKey(key)
+	return callback(key, saw)
 }
 
 func (tbl *SimpleTable) Inspect(key saw.DatumKey, callback InspectCallback) (int, error) {
+	tbl.flushKey(key)
 	saw, ok := tbl.items[key]
 	if !ok {
 		return 0, nil
@@ -159,6 +367,7 @@ func (tbl *SimpleTable) InspectSet(
 }
 
 func (tbl *SimpleTable) InspectAll(callback InspectCallback, concurrent bool) (int, error) {
+	tbl.Flush()
 	total := 0
 	for key, saw := range tbl.items {
 		err := callback(key, saw)
@@ -170,12 +379,35 @@ func (tbl *SimpleTable) InspectAll(callback InspectCallback, concurrent bool) (i
 	return total, nil
 }
 
+// InspectAllSorted is like InspectAll, but inspects items sequentially in
+// order of their keys, for reproducible output, at cost of sorting keys.
+func (tbl *SimpleTable) InspectAllSorted(callback InspectCallback) (int, error) {
+	tbl.Flush()
+	keys := tbl.sortedKeys()
+	for i, key := range keys {
+		if err := callback(key, tbl.items[key]); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+func (tbl *SimpleTable) sortedKeys() []saw.DatumKey {
+	keys := make([]saw.DatumKey, 0, len(tbl.items))
+	for key := range tbl.items {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
 // Returns TableResultMap, each item as Result() of item saw. nil item results are ignored.
 //
 // When error presents in individual items Result(), it still tries  to get results
 // of all others, then a partial result and one of the item result error will be
 // returned.
 func (tbl *SimpleTable) Result(ctx context.Context) (interface{}, error) {
+	flushErr := tbl.Flush()
 	result := make(TableResultMap)
 	var err error
 	for key, saw := range tbl.items {
@@ -186,12 +418,18 @@ func (tbl *SimpleTable) Result(ctx context.Context) (interface{}, error) {
 		}
 		result[key] = v
 	}
+	if err == nil {
+		err = flushErr
+	}
 	return result, err
 }
 
 // MemTable manages a set (spec.NumShards) of SimpleTables, provides concurrent
 // safe Emit(), stores finaly result when Result() called if there is a
 // spec.PersistentResource setting.
+//
+// In addition to vars of SimpleTable, # keys of each shard is reported in map var
+// <spec.Name>.shardKeys, to diagnose skew of KeyHashFunc.
 type MemTable struct {
 	spec   TableSpec
 	shards []*SimpleTable
@@ -199,10 +437,16 @@ type MemTable struct {
 }
 
 func NewMemTable(spec TableSpec) *MemTable {
-	fillSpecDefaults(&spec)
+	if err := fillSpecDefaults(&spec); err != nil {
+		log.Printf("%v, table %s uses default key hash", err, spec.Name)
+	}
 	shards := make([]*SimpleTable, spec.NumShards)
+	// One map var for all shards, so # vars doesn't grow with NumShards.
+	shardKeysVar := saw.ReportMap(spec.Name, "shardKeys")
 	for i := 0; i < spec.NumShards; i++ {
 		shards[i] = NewSimpleTable(spec)
+		shards[i].shardKeysVar = shardKeysVar
+		shards[i].shardLabel = strconv.Itoa(i)
 	}
 	return &MemTable{
 		spec:   spec,
@@ -212,13 +456,42 @@ func NewMemTable(spec TableSpec) *MemTable {
 }
 
 func (tbl *MemTable) Emit(kv saw.Datum) error {
-	shardIdx := tbl.spec.KeyHashFunc(kv.Key) % len(tbl.shards)
+	shardIdx := shardOf(tbl.spec.KeyHashFunc, kv.Key, len(tbl.shards))
 	simpleTable := tbl.shards[shardIdx]
 	tbl.locks[shardIdx].Lock()
 	defer tbl.locks[shardIdx].Unlock()
 	return simpleTable.Emit(kv)
 }
 
+// EmitBatch emits all data, datums are grouped by shard so that lock of each
+// shard is only taken once. Unlike Emit(), it continues when error happens in
+// a single datum and returns one of the errors encountered.
+func (tbl *MemTable) EmitBatch(data []saw.Datum) error {
+	dataByShard := make(map[int][]saw.Datum)
+	for _, datum := range data {
+		shardIdx := shardOf(tbl.spec.KeyHashFunc, datum.Key, len(tbl.shards))
+		dataByShard[shardIdx] = append(dataByShard[shardIdx], datum)
+	}
+	var firstErr error
+	for shardIdx, shardData := range dataByShard {
+		tbl.locks[shardIdx].Lock()
+		for _, datum := range shardData {
+			if err := tbl.shards[shardIdx].Emit(datum); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		tbl.locks[shardIdx].Unlock()
+	}
+	return firstErr
+}
+
+// Flush emits datums buffered when spec.BufferAndSort, see SimpleTable.Flush().
+func (tbl *MemTable) Flush() error {
+	return tbl.forEachShard(func(shardIdx int, shard *SimpleTable) error {
+		return shard.Flush()
+	}, true, false)
+}
+
 func (tbl *MemTable) forEachShard(
 	callback func(shardIdx int, shard *SimpleTable) error, concurrent bool, stopWhenErr bool) error {
 	if !concurrent {
@@ -236,41 +509,62 @@ func (tbl *MemTable) forEachShard(
 		}
 		return lastErr
 	} else {
-		var collectedErr atomic.Value
+		var errMu sync.Mutex
+		var collectedErr error
 		var wg sync.WaitGroup
-		for i, shard := range tbl.shards {
+		shardIndexes := make(chan int, len(tbl.shards))
+		for i := range tbl.shards {
+			shardIndexes <- i
+		}
+		close(shardIndexes)
+		numWorkers := tbl.spec.MaxShardConcurrency
+		if numWorkers > len(tbl.shards) {
+			numWorkers = len(tbl.shards)
+		}
+		for w := 0; w < numWorkers; w++ {
 			wg.Add(1)
-			go func(shardIdx int, shard *SimpleTable) {
+			go func() {
 				defer wg.Done()
-				tbl.locks[shardIdx].Lock()
-				defer tbl.locks[shardIdx].Unlock()
-				err := callback(shardIdx, shard)
-				if err != nil {
-					collectedErr.Store(err)
+				for shardIdx := range shardIndexes {
+					tbl.locks[shardIdx].Lock()
+					err := callback(shardIdx, tbl.shards[shardIdx])
+					tbl.locks[shardIdx].Unlock()
+					if err != nil {
+						errMu.Lock()
+						collectedErr = err
+						errMu.Unlock()
+					}
 				}
-			}(i, shard)
+			}()
 		}
 		wg.Wait()
-		if err := collectedErr.Load(); err != nil {
-			return err.(error)
-		}
-		return nil
+		return collectedErr
 	}
 }
 
 func (tbl *MemTable) Inspect(key saw.DatumKey, callback InspectCallback) (int, error) {
-	shardIdx := tbl.spec.KeyHashFunc(key) % len(tbl.shards)
+	shardIdx := shardOf(tbl.spec.KeyHashFunc, key, len(tbl.shards))
 	tbl.locks[shardIdx].Lock()
 	defer tbl.locks[shardIdx].Unlock()
 	return tbl.shards[shardIdx].Inspect(key, callback)
 }
 
+func (tbl *MemTable) inspectOrCreate(key saw.DatumKey, callback InspectCallback) error {
+	shardIdx := shardOf(tbl.spec.KeyHashFunc, key, len(tbl.shards))
+	tbl.locks[shardIdx].Lock()
+	defer tbl.locks[shardIdx].Unlock()
+	return tbl.shards[shardIdx].inspectOrCreate(key, callback)
+}
+
 func (tbl *MemTable) InspectSet(
 	keys []saw.DatumKey, callback InspectCallback, concurrent bool) (int, error) {
-	// TODO(yuheng): that's slow when key set are small, implement fast path if we need.
+	// Fast path for small key set, avoid going through all shards.
+	if len(keys) < len(tbl.shards) {
+		return tbl.inspectKeys(keys, callback, concurrent)
+	}
 	keysByShard := make([][]saw.DatumKey, len(tbl.shards))
 	for _, key := range keys {
-		shardIdx := tbl.spec.KeyHashFunc(key) % len(tbl.shards)
+		shardIdx := shardOf(tbl.spec.KeyHashFunc, key, len(tbl.shards))
 		keysByShard[shardIdx] = append(keysByShard[shardIdx], key)
 	}
 	var total int64
@@ -282,6 +576,41 @@ func (tbl *MemTable) InspectSet(
 	return int(total), err
 }
 
+// Inspects keys one by one, each under lock of its own shard.
+func (tbl *MemTable) inspectKeys(
+	keys []saw.DatumKey, callback InspectCallback, concurrent bool) (int, error) {
+	if !concurrent {
+		total := 0
+		for _, key := range keys {
+			n, err := tbl.Inspect(key, callback)
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+		return total, nil
+	}
+	var total int64
+	var errMu sync.Mutex
+	var collectedErr error
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key saw.DatumKey) {
+			defer wg.Done()
+			n, err := tbl.Inspect(key, callback)
+			atomic.AddInt64(&total, int64(n))
+			if err != nil {
+				errMu.Lock()
+				collectedErr = err
+				errMu.Unlock()
+			}
+		}(key)
+	}
+	wg.Wait()
+	return int(total), collectedErr
+}
+
 func (tbl *MemTable) InspectAll(callback InspectCallback, concurrent bool) (int, error) {
 	var total int64
 	err := tbl.forEachShard(func(shardIdx int, shard *SimpleTable) error {
@@ -292,6 +621,43 @@ func (tbl *MemTable) InspectAll(callback InspectCallback, concurrent bool) (int,
 	return int(total), err
 }
 
+// InspectAllSorted is like InspectAll, but inspects items sequentially in
+// order of their keys across all shards, for reproducible output. Keys are
+// taken as a snapshot first, items created during inspection are not visited.
+func (tbl *MemTable) InspectAllSorted(callback InspectCallback) (int, error) {
+	shardKeys := make([][]saw.DatumKey, len(tbl.shards))
+	tbl.forEachShard(func(shardIdx int, shard *SimpleTable) error {
+		shardKeys[shardIdx] = shard.sortedKeys()
+		return nil
+	}, false, false)
+
+	// Merges sorted keys of shards.
+	inspected := 0
+	pos := make([]int, len(tbl.shards))
+	for {
+		minShard := -1
+		for i, keys := range shardKeys {
+			if pos[i] < len(keys) &&
+				(minShard < 0 || keys[pos[i]] < shardKeys[minShard][pos[minShard]]) {
+				minShard = i
+			}
+		}
+		if minShard < 0 {
+			return inspected, nil
+		}
+		key := shardKeys[minShard][pos[minShard]]
+		pos[minShard]++
+		tbl.locks[minShard].Lock()
+		tbl.shards[minShard].flushKey(key)
+		err := callback(key, tbl.shards[minShard].items[key])
+		tbl.locks[minShard].Unlock()
+		if err != nil {
+			return inspected, err
+		}
+		inspected++
+	}
+}
+
 // Returns TableResultMap, each item as Result() of item saw. nil item results are ignored.
 //
 // When error presents in individual items Result(), it still tries  to get results
@@ -310,8 +676,6 @@ func (tbl *MemTable) Result(ctx context.Context) (interface{}, error) {
 		collectTable, err = NewCollectTable(ctx, collectTableSpec)
 		if err != nil {
 			finalErr = err
-		} else {
-			defer collectTable.Result(ctx)
 		}
 	}
 
@@ -334,6 +698,11 @@ func (tbl *MemTable) Result(ctx context.Context) (interface{}, error) {
 	if err != nil {
 		finalErr = err
 	}
+	if collectTable != nil {
+		if _, err := collectTable.Result(ctx); err != nil {
+			finalErr = err
+		}
+	}
 
 	resultMap := make(TableResultMap)
 	for _, m := range retByShard {
@@ -346,3 +715,109 @@ func (tbl *MemTable) Result(ctx context.Context) (interface{}, error) {
 	}
 	return resultMap, finalErr
 }
+
+// Snapshot returns TableResultMap of current state of items without ending
+// the table, so that a long-running table can be checkpointed periodically
+// while it keeps receiving Emit(). Each item is read under its shard lock, by
+// Export() for items implementing saw.ExportSaw, otherwise by Result(), see
+// InspectResult() for when the latter is safe. nil values are ignored.
+//
+// Shards are read one after another, the snapshot is consistent per item but
+// not across shards. Like Result(), one of the item errors returns along with
+// the partial result.
+func (tbl *MemTable) Snapshot(ctx context.Context) (TableResultMap, error) {
+	retByShard := make([]TableResultMap, len(tbl.shards))
+	err := tbl.forEachShard(func(shardIdx int, shard *SimpleTable) error {
+		lastErr := shard.Flush()
+		shardRet := make(TableResultMap, len(shard.items))
+		for key, item := range shard.items {
+			v, err := currentResult(ctx, item)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if v != nil {
+				shardRet[key] = v
+			}
+		}
+		retByShard[shardIdx] = shardRet
+		return lastErr
+	}, true, false)
+
+	resultMap := make(TableResultMap)
+	for _, m := range retByShard {
+		for k, v := range m {
+			resultMap[k] = v
+		}
+	}
+	return resultMap, err
+}
+
+// PersistSnapshot writes Snapshot() of the table to rc through a new
+// CollectTable, one resource per checkpoint, e.g. with timestamp in path.
+// Values are encoded by spec.ValueEncoder, items are read under lock the same
+// way as Snapshot() but written shard by shard without building the whole map.
+func (tbl *MemTable) PersistSnapshot(
+	ctx context.Context, rc storage.ResourceSpec) (ResultStreamStats, error) {
+	return tbl.persistItems(ctx, rc, "_snapshot", currentResult)
+}
+
+// Writes value of every item by valueOf to rc through a CollectTable named with
+// suffix, nil values are skipped. Tries all items, returns one of the errors.
+func (tbl *MemTable) persistItems(
+	ctx context.Context, rc storage.ResourceSpec, suffix string,
+	valueOf func(ctx context.Context, item saw.Saw) (interface{}, error)) (ResultStreamStats, error) {
+	var stats ResultStreamStats
+	collectTableSpec := tbl.spec
+	collectTableSpec.Name = collectTableSpec.Name + suffix
+	collectTableSpec.PersistentResource = rc
+	collectTable, err := NewCollectTable(ctx, collectTableSpec)
+	if err != nil {
+		return stats, err
+	}
+	finalErr := tbl.forEachShard(func(shardIdx int, shard *SimpleTable) error {
+		lastErr := shard.Flush()
+		for key, item := range shard.items {
+			v, err := valueOf(ctx, item)
+			if err == nil && v != nil {
+				err = collectTable.Emit(saw.Datum{Key: key, Value: v})
+			}
+			if err != nil {
+				atomic.AddInt64(&stats.Errors, 1)
+				lastErr = err
+			} else if v != nil {
+				atomic.AddInt64(&stats.Count, 1)
+			}
+		}
+		return lastErr
+	}, true, false)
+	if _, err := collectTable.Result(ctx); err != nil {
+		finalErr = err
+	}
+	return stats, finalErr
+}
+
+// Summary of MemTable.ResultStream()
+type ResultStreamStats struct {
+	// # of item results written to PersistentResource
+	Count int64
+	// # of items failed in Result() or writing
+	Errors int64
+}
+
+// ResultStream is like Result(), but it writes item results straight to
+// spec.PersistentResource shard by shard, without building the whole result
+// map in memory, so that table far larger than memory can be persisted. Returns
+// ErrInvalidTableSpec if PersistentResource is not set.
+//
+// Like Result(), when error presents in individual items, it still tries to
+// persist all others, then one of the errors will be returned.
+func (tbl *MemTable) ResultStream(ctx context.Context) (ResultStreamStats, error) {
+	if !tbl.spec.PersistentResource.HasSpec() {
+		return ResultStreamStats{}, ErrInvalidTableSpec
+	}
+	return tbl.persistItems(ctx, tbl.spec.PersistentResource, "_collect",
+		func(ctx context.Context, item saw.Saw) (interface{}, error) {
+			return item.Result(ctx)
+		})
+}
@@ -0,0 +1,195 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/kuangyh/saw"
+	"github.com/kuangyh/saw/aggregator"
+	"golang.org/x/net/context"
+)
+
+type testCount struct{ n int64 }
+
+func (c *testCount) Emit(v saw.Datum) error {
+	c.n++
+	return nil
+}
+
+func (c *testCount) Result(ctx context.Context) (interface{}, error) {
+	return c.n, nil
+}
+
+type testKeyError struct{ key saw.DatumKey }
+
+func (e testKeyError) Error() string { return fmt.Sprintf("key %s", e.key) }
+
+// Concurrent inspections failing with errors of different types must not panic.
+func TestMemTableInspectMixedErrors(t *testing.T) {
+	tbl := NewMemTable(TableSpec{
+		Name:        "mixedErrors",
+		ItemFactory: ItemFactoryOf(&testCount{}),
+		NumShards:   16,
+	})
+	keys := []saw.DatumKey{"a", "b", "c", "d"}
+	for _, key := range keys {
+		tbl.Emit(saw.Datum{Key: key})
+	}
+	callback := func(key saw.DatumKey, item saw.Saw) error {
+		if key == "a" || key == "c" {
+			return errors.New("plain")
+		}
+		return testKeyError{key}
+	}
+	if _, err := tbl.InspectSet(keys, callback, true); err == nil {
+		t.Error("InspectSet() returns nil, want error")
+	}
+	if _, err := tbl.InspectAll(callback, true); err == nil {
+		t.Error("InspectAll() returns nil, want error")
+	}
+}
+
+// Snapshot of aggregators is a copy, Emit() after or during it doesn't change
+// the snapshot.
+func TestMemTableSnapshotIsCopy(t *testing.T) {
+	tbl := NewMemTable(TableSpec{
+		Name:        "snapshotCopy",
+		ItemFactory: ItemFactoryOf(&aggregator.GroupCount{}),
+		NumShards:   4,
+	})
+	keys := []saw.DatumKey{"a", "b", "c"}
+	for _, key := range keys {
+		tbl.Emit(saw.Datum{Key: key, Value: "x"})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			tbl.Emit(saw.Datum{Key: keys[i%len(keys)], Value: fmt.Sprint(i % 7)})
+		}
+	}()
+	snapshot, err := tbl.Snapshot(context.Background())
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		counts := snapshot[key].(*aggregator.GroupCount).Counts
+		if counts["x"] != 1 {
+			t.Errorf("snapshot of %s counts %v, want x: 1", key, counts)
+		}
+	}
+	for _, key := range keys {
+		tbl.Emit(saw.Datum{Key: key, Value: "x"})
+	}
+	for _, key := range keys {
+		if n := snapshot[key].(*aggregator.GroupCount).Counts["x"]; n != 1 {
+			t.Errorf("snapshot of %s changed by Emit() to x: %d", key, n)
+		}
+	}
+}
+
+// Negative hash of key "i" is -i-1.
+func negativeKeyHash(key saw.DatumKey) int {
+	i, _ := strconv.Atoi(string(key))
+	return -i - 1
+}
+
+// Negative key hashes map to valid shards, spread evenly over all of them.
+func TestShardOfNegativeHash(t *testing.T) {
+	for _, numShards := range []int{127, 128} {
+		counts := make([]int, numShards)
+		for i := 0; i < numShards*10; i++ {
+			shard := shardOf(negativeKeyHash, saw.DatumKey(strconv.Itoa(i)), numShards)
+			if shard < 0 || shard >= numShards {
+				t.Fatalf("%d shards: key %d maps to shard %d", numShards, i, shard)
+			}
+			counts[shard]++
+		}
+		for shard, n := range counts {
+			if n != 10 {
+				t.Errorf("%d shards: shard %d has %d keys, want 10", numShards, shard, n)
+			}
+		}
+	}
+	tbl := NewMemTable(TableSpec{
+		Name:        "negativeHash",
+		ItemFactory: ItemFactoryOf(&testCount{}),
+		KeyHashFunc: negativeKeyHash,
+	})
+	for i := 0; i < 1000; i++ {
+		if err := tbl.Emit(saw.Datum{Key: saw.DatumKey(strconv.Itoa(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := tbl.InspectAll(func(key saw.DatumKey, item saw.Saw) error { return nil }, false); n != 1000 {
+		t.Errorf("table has %d keys, want 1000", n)
+	}
+}
+
+func benchmarkEmit(b *testing.B, numShards int) {
+	tbl := NewMemTable(TableSpec{
+		Name:        fmt.Sprintf("benchEmit%d", numShards),
+		ItemFactory: ItemFactoryOf(&testCount{}),
+		NumShards:   numShards,
+	})
+	keys := make([]saw.DatumKey, 1024)
+	for i := range keys {
+		keys[i] = saw.DatumKey(fmt.Sprintf("key%d", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tbl.Emit(saw.Datum{Key: keys[i%len(keys)]})
+	}
+}
+
+// Routing by modulo with the default 127 shards vs. bit mask with 128.
+func BenchmarkEmit(b *testing.B) {
+	b.Run("127", func(b *testing.B) { benchmarkEmit(b, 127) })
+	b.Run("128", func(b *testing.B) { benchmarkEmit(b, 128) })
+}
+
+// Looks up 3 keys of a table with default 127 shards, the fast path of
+// InspectSet() for key sets smaller than # of shards.
+func BenchmarkInspectSetSmall(b *testing.B) {
+	tbl := NewMemTable(TableSpec{
+		Name:        "benchInspectSetSmall",
+		ItemFactory: ItemFactoryOf(&testCount{}),
+	})
+	for i := 0; i < 1024; i++ {
+		tbl.Emit(saw.Datum{Key: saw.DatumKey(fmt.Sprintf("key%d", i))})
+	}
+	keys := []saw.DatumKey{"key1", "key10", "key100"}
+	callback := func(key saw.DatumKey, item saw.Saw) error { return nil }
+	for _, concurrent := range []bool{false, true} {
+		b.Run(fmt.Sprintf("concurrent=%v", concurrent), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := tbl.InspectSet(keys, callback, concurrent); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Unknown KeyHashName fails constructors returning error instead of panic.
+func TestUnknownKeyHashName(t *testing.T) {
+	spec := TableSpec{
+		Name:        "unknownKeyHash",
+		ItemFactory: ItemFactoryOf(&testCount{}),
+		KeyHashName: "no-such-hash",
+	}
+	_, err := NewCollectTable(context.Background(), spec)
+	if hashErr, ok := err.(*UnknownKeyHashError); !ok || hashErr.Name != "no-such-hash" {
+		t.Errorf("NewCollectTable() returns %v, want *UnknownKeyHashError", err)
+	}
+	if !errors.Is(err, ErrInvalidTableSpec) {
+		t.Errorf("%v is not ErrInvalidTableSpec", err)
+	}
+	tbl := NewMemTable(spec)
+	if err := tbl.Emit(saw.Datum{Key: "a"}); err != nil {
+		t.Errorf("Emit() to table of default key hash returns %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package saw
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// MultiError collects errors from a set of saws, in order of the saws, nil
+// for the ones succeeded.
+type MultiError []error
+
+func (me MultiError) Error() string {
+	msgs := make([]string, 0, len(me))
+	for _, err := range me {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Returns nil if no error in errs, MultiError otherwise.
+func collectErrors(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return MultiError(errs)
+		}
+	}
+	return nil
+}
+
+type teeSaw struct {
+	saws            []Saw
+	continueOnError bool
+}
+
+func (ts *teeSaw) Emit(datum Datum) error {
+	if !ts.continueOnError {
+		for _, saw := range ts.saws {
+			if err := saw.Emit(datum); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errs := make([]error, len(ts.saws))
+	for i, saw := range ts.saws {
+		errs[i] = saw.Emit(datum)
+	}
+	return collectErrors(errs)
+}
+
+// Result returns []interface{} of results of each saw, error is MultiError
+// if any of them fails, results of failed saws are nil.
+func (ts *teeSaw) Result(ctx context.Context) (interface{}, error) {
+	results := make([]interface{}, len(ts.saws))
+	errs := make([]error, len(ts.saws))
+	for i, saw := range ts.saws {
+		results[i], errs[i] = saw.Result(ctx)
+	}
+	return results, collectErrors(errs)
+}
+
+// Tee creates a saw that forwards datum to every saw in sequence, Emit()
+// stops at and returns the first error. Result() calls Result() of all saws,
+// returns their results in []interface{}.
+//
+// Tee is a lightweight alternative to Hub for static fan-out.
+func Tee(saws ...Saw) Saw {
+	return &teeSaw{saws: saws}
+}
+
+// TeeContinueOnError is like Tee, but Emit() forwards datum to all saws even
+// some of them fail, returns errors as MultiError.
+func TeeContinueOnError(saws ...Saw) Saw {
+	return &teeSaw{saws: saws, continueOnError: true}
+}
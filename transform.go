@@ -52,3 +52,133 @@ func RegisterTransform(hub *Hub, spec TransformSpec) {
 	}
 	hub.Register(ts, spec.Inputs...)
 }
+
+type FlatMapFunc func(input Datum) (outputs []Datum, err error)
+
+// FlatMapSpec configures a FlatMap.
+//
+// FlatMap is like Transform, but its FlatMapFunc returns any number of Datum
+// outputs for each input, each of them is published to all Outputs topics.
+// Returning empty outputs drops the input, makes FlatMap a filter.
+//
+// Use RegisterFlatMap() to create a FlatMap saw and register it to a Hub.
+type FlatMapSpec struct {
+	Name    string
+	FlatMap FlatMapFunc
+	Inputs  []TopicID
+	Outputs []TopicID
+}
+
+type flatMapSaw struct {
+	spec   FlatMapSpec
+	errVar VarInt
+	hub    *Hub
+}
+
+func (fs *flatMapSaw) Emit(datum Datum) error {
+	outputs, err := fs.spec.FlatMap(datum)
+	if err != nil {
+		fs.errVar.Add(1)
+		return err
+	}
+	for _, output := range outputs {
+		for _, topic := range fs.spec.Outputs {
+			fs.hub.Publish(topic, output)
+		}
+	}
+	return nil
+}
+
+func (fs *flatMapSaw) Result(ctx context.Context) (interface{}, error) {
+	return nil, nil
+}
+
+// RegisterFlatMap creates a FlatMap Saw instance, register it on hub with
+// spec.Inputs topics subscribed.
+func RegisterFlatMap(hub *Hub, spec FlatMapSpec) {
+	fs := &flatMapSaw{
+		spec:   spec,
+		errVar: ReportInt(spec.Name, "errors"),
+		hub:    hub,
+	}
+	hub.Register(fs, spec.Inputs...)
+}
+
+type filterSaw struct {
+	pred       func(Datum) bool
+	outputs    []TopicID
+	hub        *Hub
+	passedVar  VarInt
+	droppedVar VarInt
+}
+
+func (fs *filterSaw) Emit(datum Datum) error {
+	if !fs.pred(datum) {
+		fs.droppedVar.Add(1)
+		return nil
+	}
+	fs.passedVar.Add(1)
+	for _, topic := range fs.outputs {
+		fs.hub.Publish(topic, datum)
+	}
+	return nil
+}
+
+func (fs *filterSaw) Result(ctx context.Context) (interface{}, error) {
+	return nil, nil
+}
+
+// NewFilter creates a Filter saw and register it on hub with inputs topics
+// subscribed, it republishes datum to outputs topics only when pred returns
+// true. Counts of passed and dropped datums are reported under name. Returned
+// saw can be used to Unregister() it.
+func NewFilter(
+	hub *Hub, name string, pred func(Datum) bool, inputs, outputs []TopicID) Saw {
+	fs := &filterSaw{
+		pred:       pred,
+		outputs:    outputs,
+		hub:        hub,
+		passedVar:  ReportInt(name, "passed"),
+		droppedVar: ReportInt(name, "dropped"),
+	}
+	hub.Register(fs, inputs...)
+	return fs
+}
+
+type rekeySaw struct {
+	keyFunc    func(Datum) DatumKey
+	outputs    []TopicID
+	hub        *Hub
+	rekeyedVar VarInt
+}
+
+func (rs *rekeySaw) Emit(datum Datum) error {
+	datum.Key = rs.keyFunc(datum)
+	rs.rekeyedVar.Add(1)
+	for _, topic := range rs.outputs {
+		rs.hub.Publish(topic, datum)
+	}
+	return nil
+}
+
+func (rs *rekeySaw) Result(ctx context.Context) (interface{}, error) {
+	return nil, nil
+}
+
+// RegisterRekey creates a Rekey saw and register it on hub with inputs topics
+// subscribed, it republishes each datum to outputs topics with Key replaced by
+// keyFunc, Value and SortOrder unchanged. It's the shuffle step between two
+// aggregations, e.g. reviews keyed by user id to business id, so that tables
+// downstream shard by the new key. # of datums rekeyed are reported under
+// name. Returned saw can be used to Unregister() it.
+func RegisterRekey(
+	hub *Hub, name string, keyFunc func(Datum) DatumKey, inputs, outputs []TopicID) Saw {
+	rs := &rekeySaw{
+		keyFunc:    keyFunc,
+		outputs:    outputs,
+		hub:        hub,
+		rekeyedVar: ReportInt(name, "rekeyed"),
+	}
+	hub.Register(rs, inputs...)
+	return rs
+}
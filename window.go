@@ -1,11 +1,18 @@
 package saw
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/context"
 )
 
+var ErrFrameNotMergeable = errors.New("saw: window frame not mergeable")
+
 type SeqID int64
 
 func (seq SeqID) Advance(x int) SeqID {
@@ -19,12 +26,52 @@ func (seq SeqID) DistanceFrom(other SeqID) int {
 type DatumSeqFunc func(datum Datum) SeqID
 type WindowFrameFactory func(name string, seq SeqID) (Saw, error)
 
+// WindowFrameFactoryWithDatum also receives the datum that triggers creation of
+// the frame, so that frame can keep its metadata, e.g. time range it covers.
+type WindowFrameFactoryWithDatum func(name string, seq SeqID, firstDatum Datum) (Saw, error)
+
+// Guards a frame so that it receives no Emit() once its Result() called,
+// concurrent Emit() are still allowed.
+type windowFrame struct {
+	saw       Saw
+	mu        sync.RWMutex
+	finalized bool
+}
+
+// Returns false when frame is already finalized and datum is not emitted.
+func (frame *windowFrame) emit(datum Datum) (bool, error) {
+	frame.mu.RLock()
+	defer frame.mu.RUnlock()
+	if frame.finalized {
+		return false, nil
+	}
+	return true, frame.saw.Emit(datum)
+}
+
+// Waits for in-flight Emit() and calls Result().
+func (frame *windowFrame) result(ctx context.Context) (interface{}, error) {
+	frame.mu.Lock()
+	frame.finalized = true
+	frame.mu.Unlock()
+	return frame.saw.Result(ctx)
+}
+
 type WindowSpec struct {
 	Name          string
 	FrameFactory  WindowFrameFactory
 	SeqFunc       DatumSeqFunc
 	WindowSize    int
 	MaxSeqAdvance int
+	// When true, Result() of frames slided away are kept until Collect().
+	KeepResults bool
+	// Optional, called in finalize goroutine after each frame's Result() returns,
+	// persist completed frames etc.
+	OnFinalize func(seq SeqID, result interface{}, err error)
+	// Max # of frames finalizing concurrently, others are queued, defaults to 0
+	// (unlimited).
+	MaxFinalizeConcurrency int
+	// Optional, used instead of FrameFactory when set.
+	FrameFactoryWithDatum WindowFrameFactoryWithDatum
 }
 
 // Window implements a sliding window of saws. Window keeps finite set of saws,
@@ -39,35 +86,104 @@ type WindowSpec struct {
 // seperate gorountines. In Window.Result(), all frames' Result() will be called
 // in this maner as they all been slide away. Window doesn't care frame's Result()
 // return.
+//
+// Frames can receive concurrent Emit(), but Window guarantees a frame receives
+// no Emit() once its Result() called, datums routed to a frame being finalized
+// are dropped.
 type Window struct {
 	spec WindowSpec
 
 	mu        sync.Mutex
-	frames    []Saw
+	frames    []*windowFrame
 	startSeq  SeqID
 	latestSeq SeqID
 	startIdx  int
 	hasData   bool
 
-	finalizeWg sync.WaitGroup
+	finalizeWg      sync.WaitGroup
+	finalizeMu      sync.Mutex
+	finalizeQueue   []finalizeTask
+	finalizeWorkers int
 
-	droppedCount VarInt
+	resultsMu sync.Mutex
+	results   map[SeqID]interface{}
+	resultErr error
+
+	// Total datums dropped, and break down by reason: too late, or too far ahead
+	// beyond MaxSeqAdvance.
+	droppedCount  VarInt
+	droppedLate   VarInt
+	droppedFuture VarInt
 }
 
 func NewWindow(spec WindowSpec) *Window {
 	return &Window{
-		spec:         spec,
-		frames:       make([]Saw, spec.WindowSize),
-		droppedCount: ReportInt(spec.Name, "droppedCount"),
+		spec:          spec,
+		frames:        make([]*windowFrame, spec.WindowSize),
+		results:       make(map[SeqID]interface{}),
+		droppedCount:  ReportInt(spec.Name, "droppedCount"),
+		droppedLate:   ReportInt(spec.Name, "droppedLate"),
+		droppedFuture: ReportInt(spec.Name, "droppedFuture"),
 	}
 }
 
-func (win *Window) asyncFinalize(ctx context.Context, seq SeqID, frame Saw) {
+type finalizeTask struct {
+	ctx   context.Context
+	seq   SeqID
+	frame *windowFrame
+	keep  bool
+}
+
+func (win *Window) finalize(task finalizeTask) {
+	result, err := task.frame.result(task.ctx)
+	if win.spec.OnFinalize != nil {
+		win.spec.OnFinalize(task.seq, result, err)
+	}
+	if task.keep {
+		win.resultsMu.Lock()
+		if err != nil {
+			win.resultErr = err
+		} else {
+			win.results[task.seq] = result
+		}
+		win.resultsMu.Unlock()
+	}
+	win.finalizeWg.Done()
+}
+
+// Runs queued finalize tasks until queue drains.
+func (win *Window) finalizeWorker() {
+	for {
+		win.finalizeMu.Lock()
+		if len(win.finalizeQueue) == 0 {
+			win.finalizeWorkers--
+			win.finalizeMu.Unlock()
+			return
+		}
+		task := win.finalizeQueue[0]
+		win.finalizeQueue = win.finalizeQueue[1:]
+		win.finalizeMu.Unlock()
+		win.finalize(task)
+	}
+}
+
+// Calls frame's Result() in background, keeps result for Collect() when keep
+// is true. When WindowSpec.MaxFinalizeConcurrency is set, task is queued and
+// run by limited number of workers, never blocks caller.
+func (win *Window) asyncFinalize(ctx context.Context, seq SeqID, frame *windowFrame, keep bool) {
 	win.finalizeWg.Add(1)
-	go func() {
-		frame.Result(ctx)
-		win.finalizeWg.Done()
-	}()
+	task := finalizeTask{ctx: ctx, seq: seq, frame: frame, keep: keep}
+	if win.spec.MaxFinalizeConcurrency <= 0 {
+		go win.finalize(task)
+		return
+	}
+	win.finalizeMu.Lock()
+	defer win.finalizeMu.Unlock()
+	win.finalizeQueue = append(win.finalizeQueue, task)
+	if win.finalizeWorkers < win.spec.MaxFinalizeConcurrency {
+		win.finalizeWorkers++
+		go win.finalizeWorker()
+	}
 }
 
 func (win *Window) indexForSeq(seq SeqID) int {
@@ -78,13 +194,27 @@ func (win *Window) indexForOffset(offset int) int {
 	return (win.startIdx + offset) % len(win.frames)
 }
 
-// returning Saw nullable indicating drop
-func (win *Window) prepareFrame(datum Datum) (frame Saw, err error) {
+func (win *Window) newFrame(seq SeqID, datum Datum) (*windowFrame, error) {
+	var saw Saw
+	var err error
+	if win.spec.FrameFactoryWithDatum != nil {
+		saw, err = win.spec.FrameFactoryWithDatum(win.spec.Name, seq, datum)
+	} else {
+		saw, err = win.spec.FrameFactory(win.spec.Name, seq)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &windowFrame{saw: saw}, nil
+}
+
+// returning frame nullable indicating drop
+func (win *Window) prepareFrame(datum Datum) (frame *windowFrame, err error) {
 	seq := win.spec.SeqFunc(datum)
 	win.mu.Lock()
 	defer win.mu.Unlock()
 	if !win.hasData {
-		frame, err = win.spec.FrameFactory(win.spec.Name, seq)
+		frame, err = win.newFrame(seq, datum)
 		if err != nil {
 			return
 		}
@@ -97,22 +227,26 @@ func (win *Window) prepareFrame(datum Datum) (frame Saw, err error) {
 	}
 	offset := seq.DistanceFrom(win.startSeq)
 	// Out of window, drop
-	if offset < 0 || (win.spec.MaxSeqAdvance > 0 && offset > win.spec.MaxSeqAdvance) {
-		win.droppedCount.Add(1)
+	if offset < 0 {
+		win.droppedLate.Add(1)
+		return nil, nil
+	}
+	if win.spec.MaxSeqAdvance > 0 && offset > win.spec.MaxSeqAdvance {
+		win.droppedFuture.Add(1)
 		return nil, nil
 	}
 	winSize := len(win.frames)
 	if offset < winSize {
 		frameIdx := win.indexForOffset(offset)
 		if win.frames[frameIdx] == nil {
-			win.frames[frameIdx], err = win.spec.FrameFactory(win.spec.Name, seq)
+			win.frames[frameIdx], err = win.newFrame(seq, datum)
 			if err != nil {
 				return nil, err
 			}
 		}
 		return win.frames[frameIdx], nil
 	}
-	frame, err = win.spec.FrameFactory(win.spec.Name, seq)
+	frame, err = win.newFrame(seq, datum)
 	if err != nil {
 		return
 	}
@@ -125,15 +259,16 @@ func (win *Window) prepareFrame(datum Datum) (frame Saw, err error) {
 			frame := win.frames[frameIdx]
 			if frame != nil {
 				win.frames[frameIdx] = nil
-				win.asyncFinalize(context.Background(), win.startSeq.Advance(i), frame)
+				win.asyncFinalize(context.Background(), win.startSeq.Advance(i), frame, win.spec.KeepResults)
 			}
 		}
 		win.startSeq = seq.Advance(1 - winSize)
 		win.startIdx = 0
 	} else {
-		for i := 0; i < offset-winSize; i++ {
+		for i := 0; i <= offset-winSize; i++ {
 			if win.frames[win.startIdx] != nil {
-				win.asyncFinalize(context.Background(), win.startSeq, win.frames[win.startIdx])
+				win.asyncFinalize(
+					context.Background(), win.startSeq, win.frames[win.startIdx], win.spec.KeepResults)
 				win.frames[win.startIdx] = nil
 			}
 			win.startIdx = win.indexForOffset(1)
@@ -150,33 +285,162 @@ func (win *Window) Emit(datum Datum) error {
 		return err
 	}
 	if frame == nil {
+		// prepareFrame() already counted the reason.
 		win.droppedCount.Add(1)
 		return nil
 	}
-	return frame.Emit(datum)
+	emitted, err := frame.emit(datum)
+	if !emitted {
+		// Frame slided away after prepareFrame().
+		win.droppedLate.Add(1)
+		win.droppedCount.Add(1)
+	}
+	return err
 }
 
-// Result finalize all frames it's currently managing, returns after all frames
-// sent for finalize finishes, including previous ones caused by sliding.
-func (win *Window) Result(ctx context.Context) (result interface{}, err error) {
+// Finalizes all frames currently managing, returns after all frames sent for
+// finalize finishes, and takes kept results. Frames are detached under lock,
+// but waited without it, OnFinalize may call back into win.
+func (win *Window) finalizeAll(ctx context.Context, keep bool) (map[SeqID]interface{}, error) {
 	win.mu.Lock()
-	defer win.mu.Unlock()
 	for i := 0; i < len(win.frames); i++ {
 		frameIdx := win.indexForOffset(i)
 		frame := win.frames[frameIdx]
 		if frame != nil {
 			win.frames[frameIdx] = nil
-			win.asyncFinalize(ctx, win.startSeq.Advance(i), frame)
+			win.asyncFinalize(ctx, win.startSeq.Advance(i), frame, keep)
 		}
 	}
 	win.startSeq = 0
 	win.startIdx = 0
 	win.latestSeq = 0
 	win.hasData = false
+	win.mu.Unlock()
 	win.finalizeWg.Wait()
+
+	win.resultsMu.Lock()
+	defer win.resultsMu.Unlock()
+	results, err := win.results, win.resultErr
+	win.results = make(map[SeqID]interface{})
+	win.resultErr = nil
+	return results, err
+}
+
+// Result finalize all frames it's currently managing, returns after all frames
+// sent for finalize finishes, including previous ones caused by sliding.
+func (win *Window) Result(ctx context.Context) (result interface{}, err error) {
+	win.finalizeAll(ctx, false)
 	return nil, nil
 }
 
+// Collect is like Result(), but returns Result() of all frames it finalizes by
+// SeqID, including the ones slided away since last Collect() when
+// WindowSpec.KeepResults is set. Frames returning error are not in the map, one
+// of the errors returns.
+func (win *Window) Collect(ctx context.Context) (map[SeqID]interface{}, error) {
+	return win.finalizeAll(ctx, true)
+}
+
+// Takes all frames out of win, leaving it empty.
+func (win *Window) detachFrames() map[SeqID]*windowFrame {
+	win.mu.Lock()
+	defer win.mu.Unlock()
+	frames := make(map[SeqID]*windowFrame)
+	for i := 0; i < len(win.frames); i++ {
+		frameIdx := win.indexForOffset(i)
+		if frame := win.frames[frameIdx]; frame != nil {
+			frames[win.startSeq.Advance(i)] = frame
+			win.frames[frameIdx] = nil
+		}
+	}
+	win.startSeq = 0
+	win.startIdx = 0
+	win.latestSeq = 0
+	win.hasData = false
+	return frames
+}
+
+// Merges Export() of src frame into dst frame, src receives no Emit() after.
+func mergeFrame(dst, src *windowFrame) error {
+	src.mu.Lock()
+	src.finalized = true
+	src.mu.Unlock()
+	exportable, ok := src.saw.(ExportSaw)
+	if !ok {
+		return ErrFrameNotMergeable
+	}
+	mergeable, ok := dst.saw.(MergeSaw)
+	if !ok {
+		return ErrFrameNotMergeable
+	}
+	state, err := exportable.Export()
+	if err != nil {
+		return err
+	}
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	return mergeable.MergeFrom(state)
+}
+
+// MergeFrom takes all frames of other into win, for fan-in of partial windows
+// built on different instances. For a seq in both, frame of win merges Export()
+// of other's frame, so frames must implement MergeSaw and ExportSaw, otherwise
+// ErrFrameNotMergeable. Frames of other within win's range but missing in win
+// are adopted as they are, frames out of win's range are finalized right away,
+// as if they were slided away from win. When win has no data yet, its range
+// ends at the latest seq of other.
+//
+// other is left empty, its merged frames receive no further Emit(), it should
+// not be used concurrently. On error, the frame of other failed to merge is
+// discarded without Result(), so that its seq is only finalized once, by the
+// frame of win; others are still merged, one of the errors returns.
+func (win *Window) MergeFrom(other *Window) error {
+	frames := other.detachFrames()
+	seqs := make([]SeqID, 0, len(frames))
+	for seq := range frames {
+		seqs = append(seqs, seq)
+	}
+	sort.Sort(seqIDSort(seqs))
+
+	win.mu.Lock()
+	defer win.mu.Unlock()
+	if !win.hasData && len(seqs) > 0 {
+		win.latestSeq = seqs[len(seqs)-1]
+		win.startSeq = win.latestSeq.Advance(1 - len(win.frames))
+		win.startIdx = 0
+		win.hasData = true
+	}
+	var firstErr error
+	for _, seq := range seqs {
+		frame := frames[seq]
+		offset := seq.DistanceFrom(win.startSeq)
+		if offset < 0 || offset >= len(win.frames) {
+			win.asyncFinalize(context.Background(), seq, frame, win.spec.KeepResults)
+			continue
+		}
+		frameIdx := win.indexForOffset(offset)
+		if win.frames[frameIdx] == nil {
+			win.frames[frameIdx] = frame
+			if seq > win.latestSeq {
+				win.latestSeq = seq
+			}
+			continue
+		}
+		if err := mergeFrame(win.frames[frameIdx], frame); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+type seqIDSort []SeqID
+
+func (ss seqIDSort) Len() int           { return len(ss) }
+func (ss seqIDSort) Less(i, j int) bool { return ss[i] < ss[j] }
+func (ss seqIDSort) Swap(i, j int)      { ss[i], ss[j] = ss[j], ss[i] }
+
 // Gets the latest frame or nil when there's no data yet. returned frame is not
 // locked, would be Emit()-ing or even Result()-ing when caller gets the return.
 func (win *Window) LatestFrame() (seq SeqID, frame Saw) {
@@ -186,7 +450,31 @@ func (win *Window) LatestFrame() (seq SeqID, frame Saw) {
 	if !win.hasData {
 		return 0, nil
 	}
-	return win.latestSeq, win.frames[win.indexForSeq(win.latestSeq)]
+	if latest := win.frames[win.indexForSeq(win.latestSeq)]; latest != nil {
+		frame = latest.saw
+	}
+	return win.latestSeq, frame
+}
+
+// Gets frame of seq, false when seq is out of current window or has no frame
+// yet, frames are never created by lookup. Returned frame is not locked, would
+// be Emit()-ing or even Result()-ing when caller gets the return.
+func (win *Window) Frame(seq SeqID) (Saw, bool) {
+	win.mu.Lock()
+	defer win.mu.Unlock()
+
+	if !win.hasData {
+		return nil, false
+	}
+	offset := seq.DistanceFrom(win.startSeq)
+	if offset < 0 || offset >= len(win.frames) {
+		return nil, false
+	}
+	frame := win.frames[win.indexForOffset(offset)]
+	if frame == nil {
+		return nil, false
+	}
+	return frame.saw, true
 }
 
 // Gets the all frame the Window currently holds. returned frames are not locked,
@@ -201,8 +489,83 @@ func (win *Window) AllFrames() map[SeqID]Saw {
 	for i := 0; i < len(win.frames); i++ {
 		frame := win.frames[win.indexForOffset(i)]
 		if frame != nil {
-			output[win.startSeq.Advance(i)] = frame
+			output[win.startSeq.Advance(i)] = frame.saw
 		}
 	}
 	return output
 }
+
+// TimeWindowSpec configures a Window whose frames are fixed-duration time buckets,
+// see NewTimeWindow().
+type TimeWindowSpec struct {
+	Name          string
+	FrameFactory  WindowFrameFactory
+	TimestampFunc func(datum Datum) time.Time
+	// Duration each frame covers, buckets are aligned to Unix epoch.
+	BucketSize    time.Duration
+	WindowSize    int
+	MaxSeqAdvance int
+	// Optional, used instead of FrameFactory when set, bucket of the frame
+	// starts at TimeBucketStart(seq, BucketSize).
+	FrameFactoryWithDatum WindowFrameFactoryWithDatum
+	// Same as of WindowSpec.
+	KeepResults            bool
+	OnFinalize             func(seq SeqID, result interface{}, err error)
+	MaxFinalizeConcurrency int
+}
+
+// TimeBucketSeqFunc returns a DatumSeqFunc maps datum timestamp to sequence of
+// bucketSize-long bucket it falls in, counted from Unix epoch. Panics when
+// bucketSize <= 0.
+func TimeBucketSeqFunc(bucketSize time.Duration, timestampFunc func(datum Datum) time.Time) DatumSeqFunc {
+	if bucketSize <= 0 {
+		panic(fmt.Sprintf("saw: TimeBucketSeqFunc requires bucketSize > 0, got %v", bucketSize))
+	}
+	return func(datum Datum) SeqID {
+		nanos := timestampFunc(datum).UnixNano()
+		seq := nanos / int64(bucketSize)
+		// Rounds down for time before epoch.
+		if nanos < 0 && nanos%int64(bucketSize) != 0 {
+			seq--
+		}
+		return SeqID(seq)
+	}
+}
+
+// Gets start time of bucket seq, reverse of TimeBucketSeqFunc().
+func TimeBucketStart(seq SeqID, bucketSize time.Duration) time.Time {
+	return time.Unix(0, int64(seq)*int64(bucketSize))
+}
+
+// CountBucketSeqFunc returns a stateful DatumSeqFunc that puts every n datums
+// in a sequence, counting datums it's called with, for tumbling count windows,
+// e.g. micro-batches of fixed size with WindowSize 1: a frame is finalized as
+// soon as the first datum of the next one arrives.
+//
+// The returned function is concurrent safe, but a datum's bucket follows the
+// order of calls, use one per Window. Panics when n <= 0.
+func CountBucketSeqFunc(n int) DatumSeqFunc {
+	if n <= 0 {
+		panic(fmt.Sprintf("saw: CountBucketSeqFunc requires n > 0, got %d", n))
+	}
+	var count int64
+	return func(datum Datum) SeqID {
+		return SeqID((atomic.AddInt64(&count, 1) - 1) / int64(n))
+	}
+}
+
+// NewTimeWindow creates a Window that each frame holds datums with timestamp
+// in one bucket, and keeps spec.WindowSize latest buckets.
+func NewTimeWindow(spec TimeWindowSpec) *Window {
+	return NewWindow(WindowSpec{
+		Name:                   spec.Name,
+		FrameFactory:           spec.FrameFactory,
+		FrameFactoryWithDatum:  spec.FrameFactoryWithDatum,
+		SeqFunc:                TimeBucketSeqFunc(spec.BucketSize, spec.TimestampFunc),
+		WindowSize:             spec.WindowSize,
+		MaxSeqAdvance:          spec.MaxSeqAdvance,
+		KeepResults:            spec.KeepResults,
+		OnFinalize:             spec.OnFinalize,
+		MaxFinalizeConcurrency: spec.MaxFinalizeConcurrency,
+	})
+}
@@ -0,0 +1,270 @@
+package saw
+
+import (
+	"errors"
+	"expvar"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var errTestMerge = errors.New("merge failed")
+
+// Counts datums, MergeFrom() fails for negative counts.
+type mergeCount struct {
+	mu sync.Mutex
+	n  int64
+}
+
+func (mc *mergeCount) Emit(datum Datum) error {
+	mc.mu.Lock()
+	mc.n++
+	mc.mu.Unlock()
+	return nil
+}
+
+func (mc *mergeCount) Result(ctx context.Context) (interface{}, error) {
+	return mc.n, nil
+}
+
+func (mc *mergeCount) Export() (interface{}, error) {
+	return mc.n, nil
+}
+
+func (mc *mergeCount) MergeFrom(other interface{}) error {
+	if other.(int64) < 0 {
+		return errTestMerge
+	}
+	mc.n += other.(int64)
+	return nil
+}
+
+func newMergeCountWindow(onFinalize func(seq SeqID, result interface{}, err error)) *Window {
+	return NewWindow(WindowSpec{
+		Name:         "mergeCountWindow",
+		FrameFactory: func(name string, seq SeqID) (Saw, error) { return &mergeCount{}, nil },
+		SeqFunc:      func(datum Datum) SeqID { return SeqID(len(datum.Key)) },
+		WindowSize:   4,
+		KeepResults:  true,
+		OnFinalize:   onFinalize,
+	})
+}
+
+// A frame failed to merge is not finalized on its own, every seq is finalized
+// once, by the frame of the window merged into.
+func TestWindowMergeFromFinalizesOnce(t *testing.T) {
+	var mu sync.Mutex
+	finalized := make(map[SeqID]int)
+	win := newMergeCountWindow(func(seq SeqID, result interface{}, err error) {
+		mu.Lock()
+		finalized[seq]++
+		mu.Unlock()
+	})
+	other := newMergeCountWindow(nil)
+	for _, key := range []DatumKey{"a", "bb", "bb", "ccc"} {
+		win.Emit(Datum{Key: key})
+		other.Emit(Datum{Key: key})
+	}
+	frame, _ := other.Frame(3)
+	frame.(*mergeCount).n = -1
+
+	if err := win.MergeFrom(other); err != errTestMerge {
+		t.Errorf("MergeFrom() returns %v, want %v", err, errTestMerge)
+	}
+	results, err := win.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[SeqID]int64{1: 2, 2: 4, 3: 1}
+	for seq, n := range want {
+		if results[seq] != n {
+			t.Errorf("result of seq %d = %v, want %d", seq, results[seq], n)
+		}
+	}
+	for seq, times := range finalized {
+		if times != 1 {
+			t.Errorf("seq %d finalized %d times, want once", seq, times)
+		}
+	}
+}
+
+// Counts datums, records Emit() received after Result().
+type guardedCount struct {
+	mu         sync.Mutex
+	n          int64
+	finalized  bool
+	lateEmits  *int64
+	lateEmitMu *sync.Mutex
+}
+
+func (gc *guardedCount) Emit(datum Datum) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.finalized {
+		gc.lateEmitMu.Lock()
+		*gc.lateEmits++
+		gc.lateEmitMu.Unlock()
+		return nil
+	}
+	gc.n++
+	return nil
+}
+
+func (gc *guardedCount) Result(ctx context.Context) (interface{}, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.finalized = true
+	return gc.n, nil
+}
+
+func reportedInt(ns, name string) int64 {
+	return expvar.Get(ns + "." + name).(*expvar.Int).Value()
+}
+
+// Frames sliding away while concurrently emitted to receive no Emit() after
+// Result(), every datum is either counted by a frame or dropped.
+func TestWindowSlideWhileEmitting(t *testing.T) {
+	var lateEmits int64
+	var lateEmitMu sync.Mutex
+	win := NewWindow(WindowSpec{
+		Name: "slideWhileEmitting",
+		FrameFactory: func(name string, seq SeqID) (Saw, error) {
+			return &guardedCount{lateEmits: &lateEmits, lateEmitMu: &lateEmitMu}, nil
+		},
+		SeqFunc:     func(datum Datum) SeqID { return datum.Value.(SeqID) },
+		WindowSize:  2,
+		KeepResults: true,
+	})
+	droppedBefore := reportedInt("slideWhileEmitting", "droppedCount")
+	const numWorkers, numDatums = 8, 20000
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numDatums; i++ {
+				win.Emit(Datum{Value: SeqID(i / 2)})
+			}
+		}()
+	}
+	wg.Wait()
+	results, err := win.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lateEmits != 0 {
+		t.Errorf("%d Emit() after Result()", lateEmits)
+	}
+	var counted int64
+	for _, result := range results {
+		counted += result.(int64)
+	}
+	dropped := reportedInt("slideWhileEmitting", "droppedCount") - droppedBefore
+	if counted+dropped != numWorkers*numDatums {
+		t.Errorf("counted %d + dropped %d, want %d datums", counted, dropped, numWorkers*numDatums)
+	}
+}
+
+// Each dropped datum is counted once in droppedCount, and once by its reason.
+func TestWindowDroppedCount(t *testing.T) {
+	const name = "droppedCountWindow"
+	win := NewWindow(WindowSpec{
+		Name:          name,
+		FrameFactory:  func(name string, seq SeqID) (Saw, error) { return &mergeCount{}, nil },
+		SeqFunc:       func(datum Datum) SeqID { return datum.Value.(SeqID) },
+		WindowSize:    4,
+		MaxSeqAdvance: 8,
+	})
+	before := make(map[string]int64)
+	for _, counter := range []string{"droppedCount", "droppedLate", "droppedFuture"} {
+		before[counter] = reportedInt(name, counter)
+	}
+	for _, seq := range []SeqID{10, 5, 11, 30, 9} {
+		win.Emit(Datum{Value: seq})
+	}
+	want := map[string]int64{"droppedCount": 3, "droppedLate": 2, "droppedFuture": 1}
+	for counter, n := range want {
+		if got := reportedInt(name, counter) - before[counter]; got != n {
+			t.Errorf("%s = %d, want %d", counter, got, n)
+		}
+	}
+}
+
+// OnFinalize calling back into the window doesn't deadlock Collect().
+func TestWindowOnFinalizeCallsWindow(t *testing.T) {
+	var win *Window
+	win = newMergeCountWindow(func(seq SeqID, result interface{}, err error) {
+		win.AllFrames()
+	})
+	for _, key := range []DatumKey{"a", "bb", "ccc"} {
+		win.Emit(Datum{Key: key})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		win.Collect(context.Background())
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collect() deadlocks with OnFinalize calling AllFrames()")
+	}
+}
+
+// Time window delivers and keeps finalized buckets.
+func TestTimeWindowFinalize(t *testing.T) {
+	var mu sync.Mutex
+	finalized := make(map[SeqID]interface{})
+	win := NewTimeWindow(TimeWindowSpec{
+		Name:          "timeWindowFinalize",
+		FrameFactory:  func(name string, seq SeqID) (Saw, error) { return &mergeCount{}, nil },
+		TimestampFunc: func(datum Datum) time.Time { return datum.Value.(time.Time) },
+		BucketSize:    time.Minute,
+		WindowSize:    2,
+		KeepResults:   true,
+		OnFinalize: func(seq SeqID, result interface{}, err error) {
+			mu.Lock()
+			finalized[seq] = result
+			mu.Unlock()
+		},
+		MaxFinalizeConcurrency: 1,
+	})
+	start := time.Unix(0, 0)
+	for _, minutes := range []int{0, 0, 1, 2, 3, 3, 3} {
+		win.Emit(Datum{Value: start.Add(time.Duration(minutes) * time.Minute)})
+	}
+	results, err := win.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[SeqID]interface{}{0: int64(2), 1: int64(1), 2: int64(1), 3: int64(3)}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("Collect() = %v, want %v", results, want)
+	}
+	if !reflect.DeepEqual(finalized, want) {
+		t.Errorf("OnFinalize() with %v, want %v", finalized, want)
+	}
+}
+
+// Bucket seq funcs of non-positive size panic when created, not on first
+// datum.
+func TestBucketSeqFuncInvalidSize(t *testing.T) {
+	panics := func(name string, create func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s doesn't panic", name)
+			}
+		}()
+		create()
+	}
+	panics("CountBucketSeqFunc(0)", func() { CountBucketSeqFunc(0) })
+	panics("TimeBucketSeqFunc(-1)", func() {
+		TimeBucketSeqFunc(-1, func(datum Datum) time.Time { return time.Now() })
+	})
+	panics("NewTimeWindow() of zero BucketSize", func() {
+		NewTimeWindow(TimeWindowSpec{Name: "zeroBucket", WindowSize: 1})
+	})
+}